@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/pmezard/go-difflib/difflib"
+
+	"github.com/gtarcea/som/internal/diagnostic"
+	"github.com/gtarcea/som/internal/printer"
+)
+
+func runFmt(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the result to the source file instead of standard output")
+	diff := flags.Bool("d", false, "print a diff of the changes instead of the formatted source")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: som fmt [-w] [-d] [files...]\n\nFormats standard input when no files are given.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "som fmt: cannot use -w with standard input")
+			return 2
+		}
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "som fmt: %s\n", err)
+			return 1
+		}
+		if err := formatFile("<stdin>", string(src), false, *diff); err != nil {
+			return 1
+		}
+		return 0
+	}
+
+	status := 0
+	for _, filename := range flags.Args() {
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "som fmt: %s\n", err)
+			status = 1
+			continue
+		}
+		if err := formatFile(filename, string(src), *write, *diff); err != nil {
+			status = 1
+		}
+	}
+
+	return status
+}
+
+// formatFile formats the contents src of filename, writing it to stdout or
+// back to the file, and printing a diff when there are changes if diff is set.
+// Errors are reported on stderr as well as returned.
+func formatFile(filename, src string, write, diff bool) error {
+	formatted, err := printer.Format(src)
+	if err != nil {
+		diagnostic.Fprint(os.Stderr, filename, src, diagnostic.FromError(err))
+		return err
+	}
+
+	if diff && formatted != src {
+		d, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(src),
+			B:        difflib.SplitLines(formatted),
+			FromFile: filename + ".orig",
+			ToFile:   filename,
+			Context:  3,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "som fmt: %s\n", err)
+			return err
+		}
+		fmt.Print(d)
+	}
+
+	switch {
+	case write && formatted != src:
+		info, err := os.Stat(filename)
+		if err == nil {
+			err = ioutil.WriteFile(filename, []byte(formatted), info.Mode().Perm())
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "som fmt: %s\n", err)
+			return err
+		}
+	case !write && !diff:
+		fmt.Print(formatted)
+	}
+
+	return nil
+}
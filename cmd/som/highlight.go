@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/gtarcea/som/internal/highlight"
+	"github.com/gtarcea/som/internal/lexer"
+	"github.com/gtarcea/som/internal/parser"
+	"github.com/gtarcea/som/internal/semantic"
+)
+
+func runHighlight(args []string) int {
+	flags := flag.NewFlagSet("highlight", flag.ExitOnError)
+	htmlOutput := flags.Bool("html", false, "write an HTML page instead of coloring for the terminal")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: som highlight [-html] file.som")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	filename := flags.Arg(0)
+
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "som highlight: %s\n", err)
+		return 1
+	}
+
+	// Highlight what can be parsed even if the file has errors
+	class, _ := parser.NewWithMode(lexer.NewLexer(string(src)), parser.Tolerant).Parse()
+	semantic.Analyze(class, nil)
+	tokens := highlight.Classify(string(src), class)
+
+	if *htmlOutput {
+		fmt.Printf("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n<style>\n%s</style>\n</head>\n<body>\n",
+			html.EscapeString(filepath.Base(filename)), highlight.CSS)
+		err = highlight.WriteHTML(os.Stdout, string(src), tokens)
+		fmt.Print("</body>\n</html>\n")
+	} else {
+		err = highlight.WriteANSI(os.Stdout, string(src), tokens)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "som highlight: %s\n", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gtarcea/som/internal/lsp"
+)
+
+func runLSP(args []string) int {
+	flags := flag.NewFlagSet("lsp", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: som lsp\n\nRuns a language server speaking LSP over standard input and output.")
+	}
+	flags.Parse(args)
+
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "som lsp: %s\n", err)
+		return 1
+	}
+
+	return 0
+}
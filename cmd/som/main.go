@@ -0,0 +1,60 @@
+// Command som is the toolchain for SOM source code.
+//
+// Usage:
+//
+//	som <command> [arguments]
+//
+// The commands are:
+//
+//	fmt        format SOM source files
+//	highlight  color SOM source for terminals or HTML
+//	lsp        run a language server for editors
+//	vet        report likely mistakes in SOM source files
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	run   func(args []string) int
+	short string
+}
+
+var commands = map[string]command{
+	"fmt":       {runFmt, "format SOM source files"},
+	"highlight": {runHighlight, "color SOM source for terminals or HTML"},
+	"lsp":       {runLSP, "run a language server for editors"},
+	"vet":       {runVet, "report likely mistakes in SOM source files"},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "som: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	os.Exit(cmd.run(os.Args[2:]))
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: som <command> [arguments]\n\ncommands:")
+
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].short)
+	}
+}
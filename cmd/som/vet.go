@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/gtarcea/som/internal/ast"
+	"github.com/gtarcea/som/internal/diagnostic"
+	"github.com/gtarcea/som/internal/lexer"
+	"github.com/gtarcea/som/internal/parser"
+	"github.com/gtarcea/som/internal/vet"
+)
+
+type sourceFile struct {
+	filename string
+	src      string
+	class    *ast.Class
+	err      error
+}
+
+func runVet(args []string) int {
+	flags := flag.NewFlagSet("vet", flag.ExitOnError)
+	classpath := flags.String("cp", "", "directories of classes the checked files use, separated by "+string(os.PathListSeparator))
+	jsonOutput := flags.Bool("json", false, "print diagnostics as JSON, one object per line")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: som vet [-cp path] [-json] files or directories...")
+		fmt.Fprintln(os.Stderr, "\nSends of selectors no class implements are only reported when Object is on the class path.\n\nanalyzers:")
+		for _, a := range vet.Analyzers {
+			fmt.Fprintf(os.Stderr, "  %-12s %s\n", a.Name, a.Doc)
+		}
+		fmt.Fprintln(os.Stderr, "\nflags:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	status := 0
+
+	var library []*ast.Class
+	if *classpath != "" {
+		for _, dir := range filepath.SplitList(*classpath) {
+			files, err := loadFiles(dir)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "som vet: %s\n", err)
+				return 1
+			}
+			// Classes on the class path are only used to resolve names, their
+			// syntax errors are for checking them directly.
+			for _, f := range files {
+				library = append(library, f.class)
+			}
+		}
+	}
+
+	var files []sourceFile
+	for _, arg := range flags.Args() {
+		loaded, err := loadFiles(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "som vet: %s\n", err)
+			status = 1
+			continue
+		}
+		files = append(files, loaded...)
+	}
+
+	classes := library
+	for _, f := range files {
+		classes = append(classes, f.class)
+	}
+	program := vet.NewProgram(classes)
+
+	for _, f := range files {
+		diags := diagnostic.FromError(f.err)
+		if f.err == nil {
+			diags = vet.Check(f.class, program, vet.Analyzers)
+		}
+		if len(diags) == 0 {
+			continue
+		}
+
+		status = 1
+		var err error
+		if *jsonOutput {
+			err = diagnostic.WriteJSON(os.Stdout, f.filename, diags)
+		} else {
+			err = diagnostic.Fprint(os.Stderr, f.filename, f.src, diags)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "som vet: %s\n", err)
+			return 1
+		}
+	}
+
+	return status
+}
+
+// loadFiles parses path, or the .som files in it if it is a directory.
+func loadFiles(path string) ([]sourceFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	filenames := []string{path}
+	if info.IsDir() {
+		if filenames, err = filepath.Glob(filepath.Join(path, "*.som")); err != nil {
+			return nil, err
+		}
+	}
+
+	var files []sourceFile
+	for _, filename := range filenames {
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		class, err := parser.New(lexer.NewLexer(string(src))).Parse()
+		files = append(files, sourceFile{filename: filename, src: string(src), class: class, err: err})
+	}
+
+	return files, nil
+}
@@ -0,0 +1,271 @@
+package ast
+
+import (
+	"github.com/gtarcea/som/internal/token"
+)
+
+type Node interface {
+	Pos() token.Position
+	End() token.Position
+}
+
+type Expression interface {
+	Node
+	expressionNode()
+}
+
+// Span is the source range of a node, from the start of its first token to the
+// end of its last.
+type Span struct {
+	Start token.Position
+	Stop  token.Position
+}
+
+func (s Span) Pos() token.Position { return s.Start }
+func (s Span) End() token.Position { return s.Stop }
+
+// Ident is the declaration of a name: a class, field, argument or local.
+type Ident struct {
+	Span
+	Name string
+}
+
+// Class is a class definition. Name and Superclass are nil when they are
+// missing from the source, Superclass is also nil when it was left out to
+// default to Object. InstanceFieldsBar and ClassFieldsBar are the positions of
+// the | closing each field declaration. Comments holds every comment in the
+// source file in order.
+type Class struct {
+	Span
+	Name              *Ident
+	Superclass        *Ident
+	InstanceFields    []*Ident
+	InstanceFieldsBar token.Position
+	InstanceMethods   []*Method
+	ClassFields       []*Ident
+	ClassFieldsBar    token.Position
+	ClassMethods      []*Method
+	Comments          []token.Token
+}
+
+// Method is a method definition. Parts holds the tokens that make up the
+// selector in the pattern, a single token for unary and binary methods and one
+// per keyword for keyword methods. Open is the position of the ( starting the
+// body, or of primitive, and LocalsBar that of the | closing the locals.
+type Method struct {
+	Span
+	Selector  string
+	Parts     []token.Token
+	Arguments []*Ident
+	Open      token.Position
+	Primitive bool
+	Locals    []*Ident
+	LocalsBar token.Position
+	Body      []Expression
+	ClassSide bool
+}
+
+// Block is a block literal, [:a :b | | c | ...]. ParametersBar and LocalsBar
+// are the positions of the | closing the parameters and the locals.
+type Block struct {
+	Span
+	Parameters    []*Ident
+	ParametersBar token.Position
+	Locals        []*Ident
+	LocalsBar     token.Position
+	Body          []Expression
+}
+
+type VariableKind int
+
+const (
+	UnresolvedVariable VariableKind = iota
+	SelfVariable
+	SuperVariable
+	ThisContextVariable
+	ArgumentVariable
+	LocalVariable
+	FieldVariable
+	ClassFieldVariable
+	GlobalVariable
+)
+
+// Variable is a reference to a name inside an expression. Kind, Index and
+// Level are filled in by the semantic pass: Index is the position of the
+// argument, local or field in its declaration, counting inherited fields first,
+// and Level is the number of blocks between the reference and the method or
+// block declaring an argument or local.
+type Variable struct {
+	Span
+	Name  string
+	Kind  VariableKind
+	Index int
+	Level int
+}
+
+// Assignment is variable := value. Chained assignments nest through Value.
+type Assignment struct {
+	Span
+	Variable *Variable
+	Value    Expression
+}
+
+// Return is ^value.
+type Return struct {
+	Span
+	Value Expression
+}
+
+// Send is a unary, binary or keyword message send. Parts holds the selector
+// tokens as in Method.
+type Send struct {
+	Span
+	Receiver  Expression
+	Selector  string
+	Parts     []token.Token
+	Arguments []Expression
+}
+
+type LiteralKind int
+
+const (
+	IntegerLiteral LiteralKind = iota
+	DoubleLiteral
+	StringLiteral
+	SymbolLiteral
+)
+
+// Literal is a number, string or symbol literal. Value is the source text of
+// numbers (including any leading minus), and the contents of strings and
+// symbols without quotes, escapes or the leading #. Raw is the literal as
+// written in the source.
+type Literal struct {
+	Span
+	Kind  LiteralKind
+	Value string
+	Raw   string
+}
+
+// ArrayLiteral is #(...).
+type ArrayLiteral struct {
+	Span
+	Elements []Expression
+}
+
+// BadExpr stands in for a statement with a syntax error when parsing in
+// tolerant mode. Its span covers the source that was skipped.
+type BadExpr struct {
+	Span
+}
+
+func (*Block) expressionNode()        {}
+func (*Variable) expressionNode()     {}
+func (*Assignment) expressionNode()   {}
+func (*Return) expressionNode()       {}
+func (*Send) expressionNode()         {}
+func (*Literal) expressionNode()      {}
+func (*ArrayLiteral) expressionNode() {}
+func (*BadExpr) expressionNode()      {}
+
+func (s *Span) span() *Span { return s }
+
+// Inspect traverses the tree rooted at node in source order, calling f for
+// each node. The children of a node are skipped when f returns false.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Class:
+		inspect(f, n.Name, n.Superclass)
+		inspectIdents(f, n.InstanceFields)
+		for _, m := range n.InstanceMethods {
+			Inspect(m, f)
+		}
+		inspectIdents(f, n.ClassFields)
+		for _, m := range n.ClassMethods {
+			Inspect(m, f)
+		}
+	case *Method:
+		inspectIdents(f, n.Arguments)
+		inspectIdents(f, n.Locals)
+		inspectExpressions(f, n.Body)
+	case *Block:
+		inspectIdents(f, n.Parameters)
+		inspectIdents(f, n.Locals)
+		inspectExpressions(f, n.Body)
+	case *Assignment:
+		inspect(f, n.Variable)
+		Inspect(n.Value, f)
+	case *Return:
+		Inspect(n.Value, f)
+	case *Send:
+		Inspect(n.Receiver, f)
+		inspectExpressions(f, n.Arguments)
+	case *ArrayLiteral:
+		inspectExpressions(f, n.Elements)
+	}
+}
+
+// inspect skips nil pointers, which would otherwise be non-nil Nodes.
+func inspect(f func(Node) bool, nodes ...Node) {
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *Ident:
+			if n == nil {
+				continue
+			}
+		case *Variable:
+			if n == nil {
+				continue
+			}
+		}
+		Inspect(n, f)
+	}
+}
+
+func inspectIdents(f func(Node) bool, idents []*Ident) {
+	for _, ident := range idents {
+		inspect(f, ident)
+	}
+}
+
+func inspectExpressions(f func(Node) bool, exprs []Expression) {
+	for _, expr := range exprs {
+		Inspect(expr, f)
+	}
+}
+
+// Reposition replaces every position in the tree rooted at node with the
+// result of calling f on it, including the positions of the selector tokens of
+// methods and sends, the start of method bodies, the bars closing declarations
+// and the comments of a class.
+func Reposition(node Node, f func(token.Position) token.Position) {
+	Inspect(node, func(n Node) bool {
+		if s, ok := n.(interface{ span() *Span }); ok {
+			span := s.span()
+			span.Start, span.Stop = f(span.Start), f(span.Stop)
+		}
+
+		switch n := n.(type) {
+		case *Class:
+			n.InstanceFieldsBar, n.ClassFieldsBar = f(n.InstanceFieldsBar), f(n.ClassFieldsBar)
+			repositionTokens(n.Comments, f)
+		case *Method:
+			n.Open, n.LocalsBar = f(n.Open), f(n.LocalsBar)
+			repositionTokens(n.Parts, f)
+		case *Block:
+			n.ParametersBar, n.LocalsBar = f(n.ParametersBar), f(n.LocalsBar)
+		case *Send:
+			repositionTokens(n.Parts, f)
+		}
+		return true
+	})
+}
+
+func repositionTokens(tokens []token.Token, f func(token.Position) token.Position) {
+	for i := range tokens {
+		tokens[i].Pos, tokens[i].End = f(tokens[i].Pos), f(tokens[i].End)
+	}
+}
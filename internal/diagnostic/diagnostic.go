@@ -0,0 +1,174 @@
+package diagnostic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gtarcea/som/internal/token"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+)
+
+func (s Severity) String() string {
+	if s == Warning {
+		return "warning"
+	}
+
+	return "error"
+}
+
+// Diagnostic is a problem found in a source file. Pos and End are the zero
+// Position when the problem isn't tied to a place in the source.
+type Diagnostic struct {
+	Severity Severity
+	Pos      token.Position
+	End      token.Position
+	Message  string
+	Hint     string
+}
+
+func (d Diagnostic) Error() string {
+	if d.Pos.Line == 0 {
+		return d.Message
+	}
+
+	return fmt.Sprintf("%d:%d: %s", d.Pos.Line, d.Pos.Column, d.Message)
+}
+
+// FromError turns an error, or a multierror of them, into diagnostics. Errors
+// that know their diagnostic, such as parser errors, provide it through a
+// Diagnostic method, anything else becomes an error diagnostic without a
+// position.
+func FromError(err error) []Diagnostic {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case interface{ WrappedErrors() []error }:
+		var diags []Diagnostic
+		for _, wrapped := range e.WrappedErrors() {
+			diags = append(diags, FromError(wrapped)...)
+		}
+		return diags
+	case interface{ Diagnostic() Diagnostic }:
+		return []Diagnostic{e.Diagnostic()}
+	case Diagnostic:
+		return []Diagnostic{e}
+	}
+
+	return []Diagnostic{{Severity: Error, Message: err.Error()}}
+}
+
+// Fprint writes diagnostics for the file filename with contents src, showing
+// the offending line with the problem underlined:
+//
+//	Foo.som:2:14: error: expected expression, found ")"
+//	  a = ( ^1 + )
+//	             ^
+func Fprint(w io.Writer, filename, src string, diags []Diagnostic) error {
+	for _, d := range diags {
+		var b strings.Builder
+
+		if d.Pos.Line == 0 {
+			fmt.Fprintf(&b, "%s: %s: %s\n", filename, d.Severity, d.Message)
+		} else {
+			fmt.Fprintf(&b, "%s:%d:%d: %s: %s\n", filename, d.Pos.Line, d.Pos.Column, d.Severity, d.Message)
+			writeExcerpt(&b, src, d)
+		}
+
+		if d.Hint != "" {
+			fmt.Fprintf(&b, "hint: %s\n", d.Hint)
+		}
+
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeExcerpt writes the source line d starts on and underlines d with ^~~~,
+// up to the end of the line when d spans several lines.
+func writeExcerpt(b *strings.Builder, src string, d Diagnostic) {
+	start := d.Pos.Offset - (d.Pos.Column - 1)
+	if start < 0 || d.Pos.Offset > len(src) {
+		return
+	}
+
+	line := src[start:]
+	if i := strings.IndexByte(line, '\n'); i != -1 {
+		line = line[:i]
+	}
+	line = strings.TrimSuffix(line, "\r")
+
+	prefix := src[start:d.Pos.Offset]
+	if len(prefix) > len(line) {
+		return
+	}
+
+	end := d.End.Offset
+	if d.End.Line != d.Pos.Line || end > start+len(line) {
+		end = start + len(line)
+	}
+	width := 1
+	if end > d.Pos.Offset {
+		width = utf8.RuneCountInString(src[d.Pos.Offset:end])
+	}
+
+	b.WriteString(line)
+	b.WriteByte('\n')
+	// Keep tabs so the underline lines up with the source however tabs are shown.
+	for _, r := range prefix {
+		if r == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteByte('^')
+	b.WriteString(strings.Repeat("~", width-1))
+	b.WriteByte('\n')
+}
+
+type jsonDiagnostic struct {
+	File      string `json:"file"`
+	Line      int    `json:"line,omitempty"`
+	Column    int    `json:"column,omitempty"`
+	EndLine   int    `json:"endLine,omitempty"`
+	EndColumn int    `json:"endColumn,omitempty"`
+	Severity  string `json:"severity"`
+	Message   string `json:"message"`
+	Hint      string `json:"hint,omitempty"`
+}
+
+// WriteJSON writes diagnostics for the file filename as JSON, one object per
+// line, so that the output for several files can simply be concatenated.
+func WriteJSON(w io.Writer, filename string, diags []Diagnostic) error {
+	enc := json.NewEncoder(w)
+	for _, d := range diags {
+		jd := jsonDiagnostic{
+			File:      filename,
+			Line:      d.Pos.Line,
+			Column:    d.Pos.Column,
+			EndLine:   d.End.Line,
+			EndColumn: d.End.Column,
+			Severity:  d.Severity.String(),
+			Message:   d.Message,
+			Hint:      d.Hint,
+		}
+
+		if err := enc.Encode(jd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package diagnostic_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtarcea/som/internal/diagnostic"
+	"github.com/gtarcea/som/internal/lexer"
+	"github.com/gtarcea/som/internal/parser"
+	"github.com/gtarcea/som/internal/token"
+)
+
+const src = "Foo = (\n\ttest: x = ( ^x > 1 ifTrue: [ 'big' ] ifFalse [ 'small' ] )\n  name = ( ^'Foo\n)"
+
+func TestFprint(t *testing.T) {
+	_, err := parser.New(lexer.NewLexer(src)).Parse()
+	diags := diagnostic.FromError(err)
+	require.Len(t, diags, 2)
+
+	var b bytes.Buffer
+	require.NoError(t, diagnostic.Fprint(&b, "Foo.som", src, diags))
+
+	expected := "Foo.som:2:47: error: expected \")\" after return, found \"[\"\n" +
+		"\ttest: x = ( ^x > 1 ifTrue: [ 'big' ] ifFalse [ 'small' ] )\n" +
+		"\t                                             ^\n" +
+		"hint: did you mean `ifTrue:ifFalse:`?\n" +
+		"Foo.som:3:13: error: unterminated string\n" +
+		"  name = ( ^'Foo\n" +
+		"            ^~~~\n"
+	require.Equal(t, expected, b.String())
+}
+
+func TestFprintWithoutPosition(t *testing.T) {
+	var b bytes.Buffer
+	diags := diagnostic.FromError(errors.New("file not found"))
+	require.NoError(t, diagnostic.Fprint(&b, "Foo.som", "", diags))
+	require.Equal(t, "Foo.som: error: file not found\n", b.String())
+}
+
+func TestWriteJSON(t *testing.T) {
+	diags := []diagnostic.Diagnostic{
+		{
+			Severity: diagnostic.Warning,
+			Pos:      token.Position{Offset: 8, Line: 2, Column: 1},
+			End:      token.Position{Offset: 12, Line: 2, Column: 5},
+			Message:  "unused local",
+		},
+		{
+			Severity: diagnostic.Error,
+			Message:  "file not found",
+			Hint:     "check the classpath",
+		},
+	}
+
+	var b bytes.Buffer
+	require.NoError(t, diagnostic.WriteJSON(&b, "Foo.som", diags))
+
+	expected := `{"file":"Foo.som","line":2,"column":1,"endLine":2,"endColumn":5,"severity":"warning","message":"unused local"}
+{"file":"Foo.som","severity":"error","message":"file not found","hint":"check the classpath"}
+`
+	require.Equal(t, expected, b.String())
+}
@@ -0,0 +1,298 @@
+// Package highlight classifies the tokens of SOM source for syntax
+// highlighting, and colors source with the result for terminals and HTML.
+package highlight
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/gtarcea/som/internal/ast"
+	"github.com/gtarcea/som/internal/lexer"
+	"github.com/gtarcea/som/internal/token"
+)
+
+// Category is the kind of thing a token is, for choosing its color.
+type Category int
+
+const (
+	Comment Category = iota
+	// KeywordPart is a part of a keyword selector, such as at: in at:put:.
+	KeywordPart
+	// Selector is a unary or binary selector.
+	Selector
+	Field
+	Argument
+	Local
+	// Global is a class or other global.
+	Global
+	// PseudoVariable is self, super, nil, true, false or thisContext.
+	PseudoVariable
+	Number
+	String
+	Symbol
+	// Keyword is primitive.
+	Keyword
+	// Punctuation is a parenthesis, bracket, period, ^, :=, the bars around
+	// declarations, the = of a method definition or the ---- before the class
+	// side.
+	Punctuation
+	// Variable is a name whose declaration isn't known, such as one in code
+	// with a syntax error.
+	Variable
+	// Illegal is a character that can't start a token.
+	Illegal
+)
+
+var categoryNames = []string{
+	Comment:        "comment",
+	KeywordPart:    "keyword-part",
+	Selector:       "selector",
+	Field:          "field",
+	Argument:       "argument",
+	Local:          "local",
+	Global:         "global",
+	PseudoVariable: "pseudo-variable",
+	Number:         "number",
+	String:         "string",
+	Symbol:         "symbol",
+	Keyword:        "keyword",
+	Punctuation:    "punctuation",
+	Variable:       "variable",
+	Illegal:        "illegal",
+}
+
+func (c Category) String() string {
+	return categoryNames[c]
+}
+
+// Token is a highlighted span of source. Comments and strings may span several
+// lines.
+type Token struct {
+	Pos      token.Position
+	End      token.Position
+	Category Category
+}
+
+var pseudoVariables = map[string]bool{
+	"self":        true,
+	"super":       true,
+	"nil":         true,
+	"true":        true,
+	"false":       true,
+	"thisContext": true,
+}
+
+// Classify returns a highlighted token for every token of src in source
+// order. Every token gets a category from the lexer alone, and class, the
+// result of parsing src, refines the identifiers and selectors the parser got
+// to: it tells fields, arguments, locals and globals apart, and unary
+// selectors from variables. Tokens the parser skipped, such as those in a
+// BadExpr, keep the category the lexer gave them. class should have been
+// through semantic.Analyze so that its variables are resolved, and may be nil.
+func Classify(src string, class *ast.Class) []Token {
+	refined := refine(class)
+
+	var lexed []token.Token
+	l := lexer.NewLexer(src)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		lexed = append(lexed, tok)
+	}
+
+	var tokens []Token
+	for i, tok := range lexed {
+		if n := len(tokens); n > 0 && tok.Pos.Offset < tokens[n-1].End.Offset {
+			// Part of the previous token, such as the name in #foo
+			continue
+		}
+		if t, ok := refined[tok.Pos.Offset]; ok {
+			tokens = append(tokens, t)
+			continue
+		}
+
+		var prev, next token.Token
+		if i > 0 {
+			prev = lexed[i-1]
+		}
+		if i+1 < len(lexed) {
+			next = lexed[i+1]
+		}
+
+		t := Token{Pos: tok.Pos, End: tok.End, Category: lexicalCategory(tok, prev)}
+		switch {
+		case next.Pos != tok.End:
+		case tok.Type == token.POUND && isSymbolName(next):
+			t.End, t.Category = next.End, Symbol
+		case tok.Type == token.MINUS && (next.Type == token.INTEGER || next.Type == token.DOUBLE) && !endsOperand(prev):
+			t.End, t.Category = next.End, Number
+		}
+		tokens = append(tokens, t)
+	}
+
+	return tokens
+}
+
+// refine returns the tokens of class whose category depends on the parse,
+// by the offset they start at.
+func refine(class *ast.Class) map[int]Token {
+	refined := make(map[int]Token)
+	if class == nil {
+		return refined
+	}
+
+	add := func(node ast.Node, category Category) {
+		refined[node.Pos().Offset] = Token{Pos: node.Pos(), End: node.End(), Category: category}
+	}
+	addParts := func(parts []token.Token) {
+		for _, part := range parts {
+			category := Selector
+			if strings.HasSuffix(part.Literal, ":") {
+				category = KeywordPart
+			}
+			refined[part.Pos.Offset] = Token{Pos: part.Pos, End: part.End, Category: category}
+		}
+	}
+	addIdents := func(idents []*ast.Ident, category Category) {
+		for _, ident := range idents {
+			add(ident, category)
+		}
+	}
+
+	// Idents are classified by the node declaring them
+	ast.Inspect(class, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.Class:
+			if n.Name != nil {
+				add(n.Name, Global)
+			}
+			switch {
+			case n.Superclass != nil && pseudoVariables[n.Superclass.Name]:
+				// Object = nil ( ... )
+				add(n.Superclass, PseudoVariable)
+			case n.Superclass != nil:
+				add(n.Superclass, Global)
+			}
+			addIdents(n.InstanceFields, Field)
+			addIdents(n.ClassFields, Field)
+		case *ast.Method:
+			addParts(n.Parts)
+			addIdents(n.Arguments, Argument)
+			addIdents(n.Locals, Local)
+		case *ast.Block:
+			addIdents(n.Parameters, Argument)
+			addIdents(n.Locals, Local)
+		case *ast.Send:
+			addParts(n.Parts)
+		case *ast.Variable:
+			add(n, variableCategory(n))
+		case *ast.Literal:
+			if n.Kind == ast.SymbolLiteral && !strings.HasPrefix(n.Raw, "#") {
+				// A bare name in an array literal
+				add(n, Symbol)
+			}
+		}
+		return true
+	})
+
+	return refined
+}
+
+// lexicalCategory returns the category of tok judging by tok and the token
+// before it.
+func lexicalCategory(tok, prev token.Token) Category {
+	switch tok.Type {
+	case token.COMMENT:
+		return Comment
+	case token.STRING:
+		return String
+	case token.INTEGER, token.DOUBLE:
+		return Number
+	case token.KEYWORD, token.KEYWORD_SEQUENCE:
+		return KeywordPart
+	case token.PRIMITIVE:
+		return Keyword
+	case token.IDENTIFIER:
+		switch {
+		case pseudoVariables[tok.Literal]:
+			return PseudoVariable
+		case endsOperand(prev):
+			return Selector
+		case unicode.IsUpper(rune(tok.Literal[0])):
+			return Global
+		}
+		return Variable
+	case token.OR, token.EQUAL:
+		// Mostly the bars around declarations and the = of a definition,
+		// sends of | and = are refined to selectors
+		return Punctuation
+	case token.ILLEGAL:
+		switch {
+		case strings.HasPrefix(tok.Literal, "'"):
+			// Unterminated string
+			return String
+		case strings.HasPrefix(tok.Literal, `"`):
+			// Unterminated comment
+			return Comment
+		}
+		return Illegal
+	}
+
+	if isBinarySelector(tok) {
+		return Selector
+	}
+
+	return Punctuation
+}
+
+// endsOperand reports whether tok can be the last token of a receiver, in
+// which case an identifier after it is a unary selector.
+func endsOperand(tok token.Token) bool {
+	switch tok.Type {
+	case token.IDENTIFIER, token.INTEGER, token.DOUBLE, token.STRING, token.ENDTERM, token.ENDBLOCK:
+		return true
+	}
+
+	return false
+}
+
+// isSymbolName reports whether tok can follow # in a symbol literal.
+func isSymbolName(tok token.Token) bool {
+	switch tok.Type {
+	case token.IDENTIFIER, token.KEYWORD, token.KEYWORD_SEQUENCE, token.STRING, token.PRIMITIVE:
+		return true
+	}
+
+	return isBinarySelector(tok)
+}
+
+func isBinarySelector(tok token.Token) bool {
+	switch tok.Type {
+	case token.OR, token.NOT, token.AND, token.MULT, token.DIV, token.MOD, token.PLUS, token.MORE, token.LESS,
+		token.AT, token.PERCENT, token.COMMA, token.EQUAL, token.MINUS, token.OPERATOR_SEQUENCE:
+		return true
+	}
+
+	return false
+}
+
+func variableCategory(v *ast.Variable) Category {
+	if pseudoVariables[v.Name] {
+		return PseudoVariable
+	}
+
+	switch v.Kind {
+	case ast.ArgumentVariable:
+		return Argument
+	case ast.LocalVariable:
+		return Local
+	case ast.FieldVariable, ast.ClassFieldVariable:
+		return Field
+	case ast.GlobalVariable:
+		return Global
+	}
+
+	if unicode.IsUpper(rune(v.Name[0])) {
+		return Global
+	}
+
+	return Variable
+}
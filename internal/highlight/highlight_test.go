@@ -0,0 +1,162 @@
+package highlight
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtarcea/som/internal/ast"
+	"github.com/gtarcea/som/internal/lexer"
+	"github.com/gtarcea/som/internal/parser"
+	"github.com/gtarcea/som/internal/semantic"
+)
+
+func parse(t *testing.T, input string) *ast.Class {
+	class, err := parser.New(lexer.NewLexer(input)).Parse()
+	require.NoError(t, err)
+	semantic.Analyze(class, nil)
+	return class
+}
+
+func TestClassify(t *testing.T) {
+	input := `Foo = Bar ( | x |
+  "Sets x"
+  at: i put: v = ( | t | t := #(1 #a) , 'str'. x := [:e | e + -2.5 ] value: thisContext. ^super at: i )
+  ----
+  new = ( ^self new: nil )
+)`
+
+	var actual []string
+	for _, tok := range Classify(input, parse(t, input)) {
+		actual = append(actual, input[tok.Pos.Offset:tok.End.Offset]+" "+tok.Category.String())
+	}
+
+	require.Equal(t, []string{
+		"Foo global",
+		"= punctuation",
+		"Bar global",
+		"( punctuation",
+		"| punctuation",
+		"x field",
+		"| punctuation",
+		`"Sets x" comment`,
+		"at: keyword-part",
+		"i argument",
+		"put: keyword-part",
+		"v argument",
+		"= punctuation",
+		"( punctuation",
+		"| punctuation",
+		"t local",
+		"| punctuation",
+		"t local",
+		":= punctuation",
+		"# punctuation",
+		"( punctuation",
+		"1 number",
+		"#a symbol",
+		") punctuation",
+		", selector",
+		"'str' string",
+		". punctuation",
+		"x field",
+		":= punctuation",
+		"[ punctuation",
+		": punctuation",
+		"e argument",
+		"| punctuation",
+		"e argument",
+		"+ selector",
+		"-2.5 number",
+		"] punctuation",
+		"value: keyword-part",
+		"thisContext pseudo-variable",
+		". punctuation",
+		"^ punctuation",
+		"super pseudo-variable",
+		"at: keyword-part",
+		"i argument",
+		") punctuation",
+		"---- punctuation",
+		"new selector",
+		"= punctuation",
+		"( punctuation",
+		"^ punctuation",
+		"self pseudo-variable",
+		"new: keyword-part",
+		"nil pseudo-variable",
+		") punctuation",
+		") punctuation",
+	}, actual)
+}
+
+func TestClassifyBrokenSource(t *testing.T) {
+	// The tokens of the broken statement and the unclosed array are
+	// categorized by the lexer, the rest refined by the parse
+	input := `Foo = ( | x |
+  a: y = ( x := y foo: . ^-1 )
+  b = ( ^#( x 2 's'
+  c = primitive
+)`
+
+	class, _ := parser.NewWithMode(lexer.NewLexer(input), parser.Tolerant).Parse()
+	semantic.Analyze(class, nil)
+
+	var actual []string
+	for _, tok := range Classify(input, class) {
+		actual = append(actual, input[tok.Pos.Offset:tok.End.Offset]+" "+tok.Category.String())
+	}
+
+	require.Equal(t, []string{
+		"Foo global",
+		"= punctuation",
+		"( punctuation",
+		"| punctuation",
+		"x field",
+		"| punctuation",
+		"a: keyword-part",
+		"y argument",
+		"= punctuation",
+		"( punctuation",
+		"x variable",
+		":= punctuation",
+		"y variable",
+		"foo: keyword-part",
+		". punctuation",
+		"^ punctuation",
+		"-1 number",
+		") punctuation",
+		"b selector",
+		"= punctuation",
+		"( punctuation",
+		"^ punctuation",
+		"# punctuation",
+		"( punctuation",
+		"x variable",
+		"2 number",
+		"'s' string",
+		"c selector",
+		"= punctuation",
+		"primitive keyword",
+		") punctuation",
+	}, actual)
+}
+
+func TestWrite(t *testing.T) {
+	input := "Foo = ( a = ( ^'<b>' \"1\n2\" ) )"
+	tokens := Classify(input, parse(t, input))
+
+	var b strings.Builder
+	require.NoError(t, WriteHTML(&b, input, tokens))
+	require.Equal(t, `<pre class="som"><span class="global">Foo</span> <span class="punctuation">=</span> `+
+		`<span class="punctuation">(</span> <span class="selector">a</span> <span class="punctuation">=</span> `+
+		`<span class="punctuation">(</span> <span class="punctuation">^</span><span class="string">&#39;&lt;b&gt;&#39;</span> `+
+		`<span class="comment">&#34;1
+2&#34;</span> <span class="punctuation">)</span> <span class="punctuation">)</span></pre>
+`, b.String())
+
+	b.Reset()
+	require.NoError(t, WriteANSI(&b, input, tokens))
+	require.Equal(t, "\x1b[1;32mFoo\x1b[0m = ( \x1b[34ma\x1b[0m = ( ^\x1b[32m'<b>'\x1b[0m \x1b[2;3m\"1\x1b[0m\n\x1b[2;3m2\"\x1b[0m ) )", b.String())
+}
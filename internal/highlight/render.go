@@ -0,0 +1,103 @@
+package highlight
+
+import (
+	"html"
+	"io"
+	"strings"
+)
+
+// ansiColors are the SGR parameters used for each category.
+var ansiColors = []string{
+	Comment:        "2;3",
+	KeywordPart:    "1;34",
+	Selector:       "34",
+	Field:          "36",
+	Argument:       "33",
+	Local:          "33",
+	Global:         "1;32",
+	PseudoVariable: "35",
+	Number:         "31",
+	String:         "32",
+	Symbol:         "31",
+	Keyword:        "1;35",
+	Punctuation:    "",
+	Variable:       "",
+	Illegal:        "4;31",
+}
+
+// CSS is a style sheet for the classes used by WriteHTML.
+const CSS = `pre.som { background: #fafafa; color: #24292e; padding: 1em; }
+pre.som .comment { color: #6a737d; font-style: italic; }
+pre.som .keyword-part { color: #005cc5; font-weight: bold; }
+pre.som .selector { color: #005cc5; }
+pre.som .field { color: #0086b3; }
+pre.som .argument, pre.som .local { color: #e36209; }
+pre.som .global { color: #22863a; font-weight: bold; }
+pre.som .pseudo-variable { color: #d73a49; }
+pre.som .number, pre.som .symbol { color: #b31d28; }
+pre.som .string { color: #032f62; }
+pre.som .keyword { color: #d73a49; font-weight: bold; }
+pre.som .illegal { color: #b31d28; text-decoration: underline wavy; }
+`
+
+// WriteANSI writes src to w colored with ANSI escape sequences, for
+// terminals.
+func WriteANSI(w io.Writer, src string, tokens []Token) error {
+	return render(w, src, tokens, func(text string, category Category, ok bool) string {
+		if !ok || ansiColors[category] == "" {
+			return text
+		}
+
+		// Color each line separately so that pagers showing part of a
+		// multi-line comment still color it
+		lines := strings.Split(text, "\n")
+		for i, line := range lines {
+			if line != "" {
+				lines[i] = "\x1b[" + ansiColors[category] + "m" + line + "\x1b[0m"
+			}
+		}
+		return strings.Join(lines, "\n")
+	})
+}
+
+// WriteHTML writes src to w as a <pre class="som"> element, with each token
+// in a <span> whose class is the name of its category. CSS styles them.
+func WriteHTML(w io.Writer, src string, tokens []Token) error {
+	if _, err := io.WriteString(w, `<pre class="som">`); err != nil {
+		return err
+	}
+
+	err := render(w, src, tokens, func(text string, category Category, ok bool) string {
+		if !ok {
+			return html.EscapeString(text)
+		}
+		return `<span class="` + category.String() + `">` + html.EscapeString(text) + "</span>"
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "</pre>\n")
+	return err
+}
+
+// render writes src with format applied to each token, and to the text
+// between tokens with ok false.
+func render(w io.Writer, src string, tokens []Token, format func(text string, category Category, ok bool) string) error {
+	var b strings.Builder
+
+	offset := 0
+	for _, t := range tokens {
+		if t.Pos.Offset < offset || t.End.Offset > len(src) {
+			// Overlaps the previous token, or from a different source
+			continue
+		}
+		b.WriteString(format(src[offset:t.Pos.Offset], 0, false))
+		b.WriteString(format(src[t.Pos.Offset:t.End.Offset], t.Category, true))
+		offset = t.End.Offset
+	}
+	b.WriteString(format(src[offset:], 0, false))
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,81 @@
+package lexer
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/gtarcea/som/internal/token"
+)
+
+func FuzzNextToken(f *testing.F) {
+	seeds := []string{
+		"",
+		"Hello = (\n  run = ( 'Hello, World from SOM' println )\n)",
+		"=::= 'hello' 'hello\\'' 123 123.3\n----primitive primitiveVar\n",
+		"'unterminated",
+		"'escape at eof\\",
+		"#at:put: 1.2.3 ---- - $ \x00",
+		"x := 1.",
+		"\"comment\" <= ~= \"unterminated",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		// A lexer that loops forever is reported as a hang by go test -fuzz.
+		checkTokens(t, input)
+	})
+}
+
+// checkTokens lexes input to EOF, checking that every token makes progress and
+// has a span that lies within the input.
+func checkTokens(t *testing.T, input string) {
+	l := NewLexer(input)
+	starts := lineStarts(input)
+	offset := 0
+	// Every token other than EOF consumes at least one byte.
+	for i := 0; i <= len(input); i++ {
+		tok := l.NextToken()
+		if tok.Pos.Offset < offset || tok.End.Offset < tok.Pos.Offset || tok.End.Offset > len(input) {
+			t.Errorf("token %q has bad span %d-%d (previous token ended at %d, input length %d)",
+				tok.Literal, tok.Pos.Offset, tok.End.Offset, offset, len(input))
+			return
+		}
+		checkPosition(t, starts, tok.Pos)
+		checkPosition(t, starts, tok.End)
+
+		if tok.Type == token.EOF {
+			return
+		}
+
+		if tok.End.Offset == tok.Pos.Offset {
+			t.Errorf("token %q of type %s is empty", tok.Literal, tok.Type)
+			return
+		}
+		offset = tok.End.Offset
+	}
+
+	t.Errorf("lexer did not reach EOF on %q", input)
+}
+
+func checkPosition(t *testing.T, lineStarts []int, pos token.Position) {
+	line := sort.SearchInts(lineStarts, pos.Offset+1)
+	column := pos.Offset - lineStarts[line-1] + 1
+
+	if pos.Line != line || pos.Column != column {
+		t.Errorf("offset %d reported as %d:%d, expected %d:%d", pos.Offset, pos.Line, pos.Column, line, column)
+	}
+}
+
+// lineStarts returns the offset of the start of each line in input.
+func lineStarts(input string) []int {
+	starts := []int{0}
+	for i := 0; i < len(input); i++ {
+		if input[i] == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+
+	return starts
+}
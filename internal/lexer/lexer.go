@@ -11,10 +11,26 @@ type Lexer struct {
 	currentPosition int
 	readPosition    int
 	char            byte
+	line            int
+	lineStart       int
 }
 
 func NewLexer(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
+	l.readChar()
+	return l
+}
+
+// NewLexerAt returns a lexer for input that starts at pos rather than at the
+// beginning, so that part of the input can be lexed again with the positions
+// of its tokens unchanged.
+func NewLexerAt(input string, pos token.Position) *Lexer {
+	l := &Lexer{
+		input:        input,
+		readPosition: pos.Offset,
+		line:         pos.Line,
+		lineStart:    pos.Offset - (pos.Column - 1),
+	}
 	l.readChar()
 	return l
 }
@@ -23,8 +39,15 @@ func (l *Lexer) NextToken() token.Token {
 	var t token.Token
 
 	l.skipWhitespace()
+	pos := l.position()
 
 	switch {
+	case l.atEOF():
+		return token.Token{Type: token.EOF, Pos: pos, End: pos}
+	case isOperatorChar(l.char) && isOperatorChar(l.peekChar()) && !l.atSeparator():
+		t = l.lexOperatorSequence()
+	case l.charIs('"'):
+		t = l.lexComment()
 	case l.charIs('='):
 		t = l.newTokenFromChar(token.EQUAL)
 	case l.charIs(':'):
@@ -75,12 +98,28 @@ func (l *Lexer) NextToken() token.Token {
 		t = l.lexIdentifierOrPrimitive()
 	case isDigit(l.char):
 		t = l.lexDigit()
+	default:
+		t = l.newTokenFromChar(token.ILLEGAL)
 	}
 
 	l.readChar()
+	t.Pos = pos
+	t.End = l.position()
 	return t
 }
 
+func (l *Lexer) atEOF() bool {
+	return l.currentPosition >= len(l.input)
+}
+
+func (l *Lexer) position() token.Position {
+	return token.Position{
+		Offset: l.currentPosition,
+		Line:   l.line,
+		Column: l.currentPosition - l.lineStart + 1,
+	}
+}
+
 func (l *Lexer) charIs(c byte) bool {
 	return c == l.char
 }
@@ -96,9 +135,13 @@ func (l *Lexer) lexColon() token.Token {
 	return newToken(token.COLON, l.char)
 }
 
+func (l *Lexer) atSeparator() bool {
+	return strings.HasPrefix(l.input[l.currentPosition:], "----")
+}
+
 func (l *Lexer) lexMinus() token.Token {
 	t := token.Token{Type: token.MINUS}
-	if strings.HasPrefix(l.input[l.currentPosition:], "----") {
+	if l.atSeparator() {
 		t.Type = token.SEPARATOR
 		var b strings.Builder
 		b.WriteByte(l.char)
@@ -118,47 +161,53 @@ func (l *Lexer) lexMinus() token.Token {
 	return t
 }
 
-func (l *Lexer) lexString() token.Token {
+func (l *Lexer) lexOperatorSequence() token.Token {
 	var b strings.Builder
 
-	b.WriteString("'")
+	b.WriteByte(l.char)
+	for isOperatorChar(l.peekChar()) {
+		l.readChar()
+		b.WriteByte(l.char)
+	}
+
+	return token.Token{Type: token.OPERATOR_SEQUENCE, Literal: b.String()}
+}
+
+func (l *Lexer) lexComment() token.Token {
+	start := l.currentPosition
 	for {
 		l.readChar()
-		if l.char == '\'' {
+		if l.atEOF() {
+			return token.Token{Type: token.ILLEGAL, Literal: l.input[start:]}
+		}
+		if l.char == '"' {
 			break
 		}
-		l.lexStringChar(&b)
 	}
-	b.WriteString("'")
-	return token.Token{Type: token.STRING, Literal: b.String()}
-}
 
-func (l *Lexer) lexStringChar(b *strings.Builder) {
-	if l.char == '\\' {
-		l.lexEscapeChar(b)
-	} else {
-		b.WriteByte(l.char)
-	}
+	return token.Token{Type: token.COMMENT, Literal: l.input[start : l.currentPosition+1]}
 }
 
-func (l *Lexer) lexEscapeChar(b *strings.Builder) {
-	l.readChar()
-	switch l.char {
-	case 't':
-		b.WriteString("\t")
-	case 'b':
-		b.WriteString("\b")
-	case 'n':
-		b.WriteString("\n")
-	case 'r':
-		b.WriteString("\r")
-	case 'f':
-		b.WriteString("\f")
-	case '\'':
-		b.WriteString("\\'")
-	case '\\':
-		b.WriteString("\\")
+// lexString returns the string as it appears in the source, quotes and escapes
+// included, the parser takes care of the escapes.
+func (l *Lexer) lexString() token.Token {
+	start := l.currentPosition
+	for {
+		l.readChar()
+		if l.char == '\\' {
+			// Skip the escaped character so that \' doesn't end the string
+			l.readChar()
+		} else if l.char == '\'' {
+			break
+		}
+
+		if l.atEOF() {
+			// Unterminated string, hand back what we saw so the parser can report it.
+			return token.Token{Type: token.ILLEGAL, Literal: l.input[start:]}
+		}
 	}
+
+	return token.Token{Type: token.STRING, Literal: l.input[start : l.currentPosition+1]}
 }
 
 func (l *Lexer) lexIdentifierOrPrimitive() token.Token {
@@ -217,29 +266,28 @@ Loop:
 	for {
 		char := l.peekChar()
 		switch {
-		case char == '.' && !sawPeriod:
-			char2 := l.peek2Char()
-			if isDigit(char2) {
-				// At this point we have seen a string that looks as follows:
-				//   111.111
-				// That is we've seen 1 or more numbers and then the peek shows us
-				// a period, and peek2 shows a number after the period, so we are
-				// reading a double. Also !sawPeriod ensures we haven't yet seen
-				// a period. (The next time we see a period we know we are no longer
-				// lexing a number and will exit the loop.)
-
-				// advance lexer so that l.char == '.'
-				l.readChar()
-				// Now that we've seen a period make sure we don't drop back into this block
-				sawPeriod = true
-				// We are reading a double at this point
-				t.Type = token.DOUBLE
-			}
+		case char == '.' && !sawPeriod && isDigit(l.peek2Char()):
+			// At this point we have seen a string that looks as follows:
+			//   111.111
+			// That is we've seen 1 or more numbers and then the peek shows us
+			// a period, and peek2 shows a number after the period, so we are
+			// reading a double. Also !sawPeriod ensures we haven't yet seen
+			// a period. (The next time we see a period we know we are no longer
+			// lexing a number and will exit the loop.)
+
+			// advance lexer so that l.char == '.'
+			l.readChar()
+			// Now that we've seen a period make sure we don't drop back into this block
+			sawPeriod = true
+			// We are reading a double at this point
+			t.Type = token.DOUBLE
 		case isDigit(char):
 			// peek is a digit so advance lexer so that l.char is that digit
 			l.readChar()
 		default:
-			// Either we are seeing a second period or a non-numeric character - either way exit loop
+			// Either we are seeing a second period, a period that isn't followed by a
+			// digit (eg the end of a statement) or a non-numeric character - either
+			// way exit loop
 			break Loop
 		}
 
@@ -258,12 +306,21 @@ func (l *Lexer) skipWhitespace() {
 }
 
 func (l *Lexer) readChar() {
+	if l.char == '\n' {
+		l.line++
+		l.lineStart = l.readPosition
+	}
+
 	if l.readPosition >= len(l.input) {
+		// Park on EOF instead of walking past the end of the input, l.char == 0
+		// alone can't be trusted as the input may contain NUL bytes.
 		l.char = 0
-	} else {
-		l.char = l.input[l.readPosition]
+		l.currentPosition = len(l.input)
+		l.readPosition = len(l.input) + 1
+		return
 	}
 
+	l.char = l.input[l.readPosition]
 	l.currentPosition = l.readPosition
 	l.readPosition += 1
 }
@@ -330,6 +387,10 @@ func isIdentifierChar(char byte) bool {
 	return isAlphaNumeric(char) || char == '_'
 }
 
+func isOperatorChar(char byte) bool {
+	return strings.IndexByte("~&|*/\\+=><,@%-", char) != -1
+}
+
 func (l *Lexer) newTokenFromChar(tokenType token.Type) token.Token {
 	return token.Token{Type: tokenType, Literal: string(l.char)}
 }
@@ -37,3 +37,80 @@ func TestNextToken(t *testing.T) {
 		})
 	}
 }
+
+func TestNextTokenIllegal(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedLiteral string
+	}{
+		{"'unterminated", "'unterminated"},
+		{"'escape at eof\\", "'escape at eof\\"},
+		{"$", "$"},
+		{"\x00", "\x00"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			l := NewLexer(test.input)
+			tok := l.NextToken()
+			require.Equal(t, token.Type(token.ILLEGAL), tok.Type)
+			require.Equal(t, test.expectedLiteral, tok.Literal)
+			require.Equal(t, token.Type(token.EOF), l.NextToken().Type)
+		})
+	}
+}
+
+func TestNextTokenPositions(t *testing.T) {
+	input := "Foo = (\n  bar = ( ^'x' )\n)"
+	tests := []struct {
+		expectedLiteral string
+		expectedPos     token.Position
+	}{
+		{"Foo", token.Position{Offset: 0, Line: 1, Column: 1}},
+		{"=", token.Position{Offset: 4, Line: 1, Column: 5}},
+		{"(", token.Position{Offset: 6, Line: 1, Column: 7}},
+		{"bar", token.Position{Offset: 10, Line: 2, Column: 3}},
+		{"=", token.Position{Offset: 14, Line: 2, Column: 7}},
+		{"(", token.Position{Offset: 16, Line: 2, Column: 9}},
+		{"^", token.Position{Offset: 18, Line: 2, Column: 11}},
+		{"'x'", token.Position{Offset: 19, Line: 2, Column: 12}},
+		{")", token.Position{Offset: 23, Line: 2, Column: 16}},
+		{")", token.Position{Offset: 25, Line: 3, Column: 1}},
+		{"", token.Position{Offset: 26, Line: 3, Column: 2}},
+	}
+
+	l := NewLexer(input)
+	for _, test := range tests {
+		tok := l.NextToken()
+		require.Equal(t, test.expectedLiteral, tok.Literal)
+		require.Equal(t, test.expectedPos, tok.Pos)
+	}
+}
+
+func TestNextTokenOperatorsAndComments(t *testing.T) {
+	input := `"a comment" <= ~= , ---- -1 |x| "unterminated`
+	tests := []struct {
+		expectedTokenType token.Type
+		expectedLiteral   string
+	}{
+		{token.COMMENT, `"a comment"`},
+		{token.OPERATOR_SEQUENCE, "<="},
+		{token.OPERATOR_SEQUENCE, "~="},
+		{token.COMMA, ","},
+		{token.SEPARATOR, "----"},
+		{token.MINUS, "-"},
+		{token.INTEGER, "1"},
+		{token.OR, "|"},
+		{token.IDENTIFIER, "x"},
+		{token.OR, "|"},
+		{token.ILLEGAL, `"unterminated`},
+		{token.EOF, ""},
+	}
+
+	l := NewLexer(input)
+	for _, test := range tests {
+		tok := l.NextToken()
+		require.Equal(t, test.expectedTokenType, tok.Type)
+		require.Equal(t, test.expectedLiteral, tok.Literal)
+	}
+}
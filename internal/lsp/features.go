@@ -0,0 +1,665 @@
+package lsp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gtarcea/som/internal/ast"
+	"github.com/gtarcea/som/internal/highlight"
+	"github.com/gtarcea/som/internal/semantic"
+	"github.com/gtarcea/som/internal/token"
+)
+
+// target is what is under the cursor.
+type target struct {
+	// selector is set for a message send or a method pattern, and method for
+	// the latter.
+	selector string
+	method   *ast.Method
+	variable *ast.Variable
+	ident    *ast.Ident
+	// scopes are the methods and blocks around the cursor, outermost first.
+	scopes []ast.Node
+}
+
+// targetAt finds what is at offset in f. A position just after a name counts
+// as being on it, as that is where the cursor is after typing it.
+func targetAt(f *file, offset int) target {
+	var t target
+
+	contains := func(pos, end token.Position) bool {
+		return pos.Offset <= offset && offset <= end.Offset
+	}
+	onParts := func(parts []token.Token) bool {
+		for _, part := range parts {
+			if contains(part.Pos, part.End) {
+				return true
+			}
+		}
+		return false
+	}
+
+	ast.Inspect(f.class, func(node ast.Node) bool {
+		if _, ok := node.(*ast.Class); !ok && !contains(node.Pos(), node.End()) {
+			return false
+		}
+
+		switch n := node.(type) {
+		case *ast.Method:
+			t.scopes = append(t.scopes, n)
+			if onParts(n.Parts) {
+				t.selector, t.method = n.Selector, n
+			}
+		case *ast.Block:
+			t.scopes = append(t.scopes, n)
+		case *ast.Send:
+			if onParts(n.Parts) {
+				t.selector = n.Selector
+			}
+		case *ast.Variable:
+			t.variable = n
+		case *ast.Ident:
+			t.ident = n
+		}
+		return true
+	})
+
+	return t
+}
+
+// localScope returns the method or block declaring the argument or local
+// under the cursor, whether it is the declaration itself or a use of it.
+func (t target) localScope() ast.Node {
+	if t.variable != nil {
+		if (t.variable.Kind == ast.ArgumentVariable || t.variable.Kind == ast.LocalVariable) && t.variable.Level < len(t.scopes) {
+			return t.scopes[len(t.scopes)-1-t.variable.Level]
+		}
+		return nil
+	}
+
+	if t.ident != nil && len(t.scopes) > 0 {
+		for _, ident := range declared(t.scopes[len(t.scopes)-1]) {
+			if ident == t.ident {
+				return t.scopes[len(t.scopes)-1]
+			}
+		}
+	}
+
+	return nil
+}
+
+// declared returns the arguments and locals of a method or block.
+func declared(scope ast.Node) []*ast.Ident {
+	switch scope := scope.(type) {
+	case *ast.Method:
+		return append(scope.Arguments[:len(scope.Arguments):len(scope.Arguments)], scope.Locals...)
+	case *ast.Block:
+		return append(scope.Parameters[:len(scope.Parameters):len(scope.Parameters)], scope.Locals...)
+	}
+
+	return nil
+}
+
+func (s *Server) definition(p TextDocumentPositionParams) []Location {
+	f, ok := s.files[p.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	t := targetAt(f, f.offset(p.Position))
+
+	locations := []Location{}
+	switch {
+	case t.selector != "":
+		s.implementors(t.selector, func(f *file, m *ast.Method) {
+			locations = append(locations, f.location(patternSpan(m)))
+		})
+	case t.variable != nil:
+		if ident := s.declaration(f, t); ident != nil {
+			locations = append(locations, s.fileOf(f, ident).location(ident.Pos(), ident.End()))
+		}
+	case t.ident != nil:
+		if c, ok := s.classes[t.ident.Name]; ok && t.ident != f.class.Name {
+			locations = append(locations, c.location(c.class.Name.Pos(), c.class.Name.End()))
+		}
+	}
+
+	return locations
+}
+
+// declaration returns the declaration of the variable in t, which may be in a
+// superclass in another file.
+func (s *Server) declaration(f *file, t target) *ast.Ident {
+	v := t.variable
+
+	switch v.Kind {
+	case ast.ArgumentVariable, ast.LocalVariable:
+		for _, ident := range declared(t.localScope()) {
+			if ident.Name == v.Name {
+				return ident
+			}
+		}
+	case ast.FieldVariable, ast.ClassFieldVariable:
+		var found *ast.Ident
+		s.classChain(f.class, func(c *ast.Class) bool {
+			fields := c.InstanceFields
+			if v.Kind == ast.ClassFieldVariable {
+				fields = c.ClassFields
+			}
+			for _, ident := range fields {
+				if ident.Name == v.Name {
+					found = ident
+					return false
+				}
+			}
+			return true
+		})
+		return found
+	case ast.GlobalVariable, ast.UnresolvedVariable:
+		if c, ok := s.classes[v.Name]; ok {
+			return c.class.Name
+		}
+	}
+
+	return nil
+}
+
+// fileOf returns the file that ident is declared in, starting with f.
+func (s *Server) fileOf(f *file, ident *ast.Ident) *file {
+	for _, other := range append([]*file{f}, s.sortedFiles()...) {
+		found := false
+		ast.Inspect(other.class, func(node ast.Node) bool {
+			found = found || node == ident
+			return !found
+		})
+		if found {
+			return other
+		}
+	}
+
+	return f
+}
+
+// classChain calls f with class and then each of its superclasses in the
+// workspace, until f returns false.
+func (s *Server) classChain(class *ast.Class, f func(*ast.Class) bool) {
+	seen := make(map[*ast.Class]bool)
+	for c := class; c != nil && !seen[c]; {
+		seen[c] = true
+		if !f(c) {
+			return
+		}
+
+		switch {
+		case c.Superclass != nil:
+			c = s.LookupClass(c.Superclass.Name)
+		case c.Name != nil && c.Name.Name != "Object":
+			c = s.LookupClass("Object")
+		default:
+			c = nil
+		}
+	}
+}
+
+// implementors calls f for each method with the given selector in the
+// workspace.
+func (s *Server) implementors(selector string, f func(*file, *ast.Method)) {
+	for _, file := range s.sortedFiles() {
+		for _, m := range append(file.class.InstanceMethods, file.class.ClassMethods...) {
+			if m.Selector == selector {
+				f(file, m)
+			}
+		}
+	}
+}
+
+func (s *Server) references(p ReferenceParams) []Location {
+	f, ok := s.files[p.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	t := targetAt(f, f.offset(p.Position))
+
+	locations := []Location{}
+	switch {
+	case t.selector != "":
+		if p.Context.IncludeDeclaration {
+			s.implementors(t.selector, func(f *file, m *ast.Method) {
+				locations = append(locations, f.location(patternSpan(m)))
+			})
+		}
+		for _, file := range s.sortedFiles() {
+			ast.Inspect(file.class, func(node ast.Node) bool {
+				if send, ok := node.(*ast.Send); ok && send.Selector == t.selector {
+					locations = append(locations, file.location(send.Parts[0].Pos, send.Parts[len(send.Parts)-1].End))
+				}
+				return true
+			})
+		}
+	case t.localScope() != nil:
+		scope, ident := t.localScope(), t.ident
+		if t.variable != nil {
+			ident = s.declaration(f, t)
+		}
+		if p.Context.IncludeDeclaration && ident != nil {
+			locations = append(locations, f.location(ident.Pos(), ident.End()))
+		}
+		for _, v := range variablesIn(scope, ident.Name) {
+			locations = append(locations, f.location(v.Pos(), v.End()))
+		}
+	case t.variable != nil && (t.variable.Kind == ast.FieldVariable || t.variable.Kind == ast.ClassFieldVariable):
+		ast.Inspect(f.class, func(node ast.Node) bool {
+			if v, ok := node.(*ast.Variable); ok && v.Name == t.variable.Name && v.Kind == t.variable.Kind {
+				locations = append(locations, f.location(v.Pos(), v.End()))
+			}
+			return true
+		})
+	case t.variable != nil || t.ident != nil:
+		name := ""
+		if t.variable != nil {
+			name = t.variable.Name
+		} else {
+			name = t.ident.Name
+		}
+		c, ok := s.classes[name]
+		if !ok {
+			break
+		}
+		if p.Context.IncludeDeclaration {
+			locations = append(locations, c.location(c.class.Name.Pos(), c.class.Name.End()))
+		}
+		for _, file := range s.sortedFiles() {
+			ast.Inspect(file.class, func(node ast.Node) bool {
+				switch n := node.(type) {
+				case *ast.Variable:
+					if n.Name == name {
+						locations = append(locations, file.location(n.Pos(), n.End()))
+					}
+				case *ast.Class:
+					if n.Superclass != nil && n.Superclass.Name == name {
+						locations = append(locations, file.location(n.Superclass.Pos(), n.Superclass.End()))
+					}
+				}
+				return true
+			})
+		}
+	}
+
+	return locations
+}
+
+// variablesIn returns the uses in scope of the argument or local called name
+// that scope declares, skipping those in blocks that declare the name again.
+func variablesIn(scope ast.Node, name string) []*ast.Variable {
+	var vars []*ast.Variable
+
+	var walk func(node ast.Node, level int)
+	walk = func(node ast.Node, level int) {
+		ast.Inspect(node, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.Block:
+				if n != node {
+					walk(n, level+1)
+					return false
+				}
+			case *ast.Variable:
+				if n.Name == name && n.Level == level && (n.Kind == ast.ArgumentVariable || n.Kind == ast.LocalVariable) {
+					vars = append(vars, n)
+				}
+			}
+			return true
+		})
+	}
+	walk(scope, 0)
+
+	return vars
+}
+
+func (s *Server) hover(p TextDocumentPositionParams) *Hover {
+	f, ok := s.files[p.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	t := targetAt(f, f.offset(p.Position))
+
+	var sections []string
+	switch {
+	case t.selector != "":
+		s.implementors(t.selector, func(file *file, m *ast.Method) {
+			section := "```som\n" + className(file.class, m.ClassSide) + ">>" + pattern(file, m) + "\n```"
+			if comment := methodComment(file, m); comment != "" {
+				section += "\n\n" + comment
+			}
+			sections = append(sections, section)
+		})
+	case t.variable != nil && t.variable.Kind != ast.GlobalVariable && t.variable.Kind != ast.UnresolvedVariable:
+		sections = append(sections, "```som\n"+variableKind(t.variable.Kind)+" "+t.variable.Name+"\n```")
+	case t.variable != nil || t.ident != nil:
+		name := ""
+		if t.variable != nil {
+			name = t.variable.Name
+		} else {
+			name = t.ident.Name
+		}
+		if c, ok := s.classes[name]; ok {
+			section := "```som\n" + classHeader(c.class) + "\n```"
+			if comment := classComment(c); comment != "" {
+				section += "\n\n" + comment
+			}
+			sections = append(sections, section)
+		}
+	}
+
+	if len(sections) == 0 {
+		return nil
+	}
+
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: strings.Join(sections, "\n\n---\n\n")}}
+}
+
+func className(class *ast.Class, classSide bool) string {
+	name := "?"
+	if class.Name != nil {
+		name = class.Name.Name
+	}
+	if classSide {
+		name += " class"
+	}
+
+	return name
+}
+
+func classHeader(class *ast.Class) string {
+	superclass := "Object"
+	if class.Superclass != nil {
+		superclass = class.Superclass.Name
+	}
+
+	return className(class, false) + " = " + superclass
+}
+
+func variableKind(kind ast.VariableKind) string {
+	switch kind {
+	case ast.SelfVariable, ast.SuperVariable, ast.ThisContextVariable:
+		return "pseudo-variable"
+	case ast.ArgumentVariable:
+		return "argument"
+	case ast.LocalVariable:
+		return "local"
+	case ast.FieldVariable:
+		return "field"
+	case ast.ClassFieldVariable:
+		return "class-side field"
+	}
+
+	return "global"
+}
+
+// patternSpan returns the span of the pattern of m, from its selector up to
+// its last argument.
+func patternSpan(m *ast.Method) (token.Position, token.Position) {
+	end := m.Parts[len(m.Parts)-1].End
+	if len(m.Arguments) > 0 && m.Arguments[len(m.Arguments)-1].End().Offset > end.Offset {
+		end = m.Arguments[len(m.Arguments)-1].End()
+	}
+
+	return m.Pos(), end
+}
+
+// pattern returns the pattern of m as written, with spacing normalized.
+func pattern(f *file, m *ast.Method) string {
+	pos, end := patternSpan(m)
+	return strings.Join(strings.Fields(f.text[pos.Offset:end.Offset]), " ")
+}
+
+// methodComment returns the comments between m and whatever comes before it,
+// or failing that the comment starting its body, which is where SOM code
+// usually documents methods.
+func methodComment(f *file, m *ast.Method) string {
+	start := 0
+	if f.class.Name != nil {
+		start = f.class.Name.End().Offset
+	}
+	for _, other := range append(f.class.InstanceMethods, f.class.ClassMethods...) {
+		if end := other.End().Offset; end <= m.Pos().Offset && end > start {
+			start = end
+		}
+	}
+	for _, field := range append(f.class.InstanceFields, f.class.ClassFields...) {
+		if end := field.End().Offset; end <= m.Pos().Offset && end > start {
+			start = end
+		}
+	}
+
+	if comment := comments(f.class, start, m.Pos().Offset); comment != "" {
+		return comment
+	}
+
+	_, patternEnd := patternSpan(m)
+	bodyStart := m.End().Offset
+	if len(m.Body) > 0 {
+		bodyStart = m.Body[0].Pos().Offset
+	}
+	for _, c := range f.class.Comments {
+		if c.Pos.Offset > patternEnd.Offset && c.Pos.Offset < bodyStart {
+			return commentText(c)
+		}
+	}
+
+	return ""
+}
+
+// classComment returns the comments before the class definition in f.
+func classComment(f *file) string {
+	return comments(f.class, 0, f.class.Pos().Offset)
+}
+
+// comments returns the text of the comments in class between start and end.
+func comments(class *ast.Class, start, end int) string {
+	var texts []string
+	for _, c := range class.Comments {
+		if c.Pos.Offset >= start && c.End.Offset <= end {
+			texts = append(texts, commentText(c))
+		}
+	}
+
+	return strings.Join(texts, "\n\n")
+}
+
+func commentText(c token.Token) string {
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(c.Literal, `"`), `"`))
+}
+
+func (s *Server) documentSymbols(p DocumentSymbolParams) []DocumentSymbol {
+	f, ok := s.files[p.TextDocument.URI]
+	if !ok || f.class.Name == nil {
+		return []DocumentSymbol{}
+	}
+
+	class := DocumentSymbol{
+		Name:           f.class.Name.Name,
+		Detail:         classHeader(f.class),
+		Kind:           SymbolClass,
+		Range:          f.rangeOf(f.class.Pos(), f.class.End()),
+		SelectionRange: f.rangeOf(f.class.Name.Pos(), f.class.Name.End()),
+	}
+
+	fields := func(idents []*ast.Ident, detail string) {
+		for _, ident := range idents {
+			class.Children = append(class.Children, DocumentSymbol{
+				Name:           ident.Name,
+				Detail:         detail,
+				Kind:           SymbolField,
+				Range:          f.rangeOf(ident.Pos(), ident.End()),
+				SelectionRange: f.rangeOf(ident.Pos(), ident.End()),
+			})
+		}
+	}
+	methods := func(ms []*ast.Method, detail string) {
+		for _, m := range ms {
+			pos, end := patternSpan(m)
+			class.Children = append(class.Children, DocumentSymbol{
+				Name:           m.Selector,
+				Detail:         detail,
+				Kind:           SymbolMethod,
+				Range:          f.rangeOf(m.Pos(), m.End()),
+				SelectionRange: f.rangeOf(pos, end),
+			})
+		}
+	}
+
+	fields(f.class.InstanceFields, "")
+	methods(f.class.InstanceMethods, "")
+	fields(f.class.ClassFields, "class side")
+	methods(f.class.ClassMethods, "class side")
+
+	return []DocumentSymbol{class}
+}
+
+func (s *Server) completion(p TextDocumentPositionParams) []CompletionItem {
+	f, ok := s.files[p.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	offset := f.offset(p.Position)
+
+	start := offset
+	for start > 0 && isSelectorChar(f.text[start-1]) {
+		start--
+	}
+	prefix := f.text[start:offset]
+
+	items := []CompletionItem{}
+	seen := make(map[string]bool)
+	add := func(label string, kind int, detail string) {
+		if strings.HasPrefix(label, prefix) && !seen[label] {
+			seen[label] = true
+			items = append(items, CompletionItem{Label: label, Kind: kind, Detail: detail})
+		}
+	}
+
+	// Names in scope first, innermost first
+	t := targetAt(f, offset)
+	for i := len(t.scopes) - 1; i >= 0; i-- {
+		switch scope := t.scopes[i].(type) {
+		case *ast.Method:
+			addIdents(add, scope.Arguments, CompletionVariable, "argument")
+			addIdents(add, scope.Locals, CompletionVariable, "local")
+		case *ast.Block:
+			addIdents(add, scope.Parameters, CompletionVariable, "argument")
+			addIdents(add, scope.Locals, CompletionVariable, "local")
+		}
+	}
+
+	classSide := false
+	if len(t.scopes) > 0 {
+		classSide = t.scopes[0].(*ast.Method).ClassSide
+	}
+	s.classChain(f.class, func(c *ast.Class) bool {
+		if classSide {
+			addIdents(add, c.ClassFields, CompletionField, "field of "+className(c, true))
+		} else {
+			addIdents(add, c.InstanceFields, CompletionField, "field of "+className(c, false))
+		}
+		return true
+	})
+
+	// Then selectors with the classes implementing them
+	implementors := make(map[string][]string)
+	for _, file := range s.sortedFiles() {
+		for _, m := range append(file.class.InstanceMethods, file.class.ClassMethods...) {
+			implementors[m.Selector] = append(implementors[m.Selector], className(file.class, m.ClassSide))
+		}
+	}
+	var selectors []string
+	for selector := range implementors {
+		selectors = append(selectors, selector)
+	}
+	sort.Strings(selectors)
+	for _, selector := range selectors {
+		add(selector, CompletionMethod, strings.Join(implementors[selector], ", "))
+	}
+
+	var classes []string
+	for name := range s.classes {
+		classes = append(classes, name)
+	}
+	sort.Strings(classes)
+	for _, name := range classes {
+		add(name, CompletionClass, fmt.Sprintf("class %s", name))
+	}
+
+	return items
+}
+
+func addIdents(add func(string, int, string), idents []*ast.Ident, kind int, detail string) {
+	for _, ident := range idents {
+		add(ident.Name, kind, detail)
+	}
+}
+
+func isSelectorChar(c byte) bool {
+	return c == '_' || c == ':' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// semanticTokenTypes is the legend for semantic tokens. Keyword parts and
+// unary and binary selectors are all methods. Punctuation and illegal
+// characters have no type and are left out.
+var semanticTokenTypes = []string{"comment", "method", "property", "parameter", "variable", "class", "keyword", "number", "string", "enumMember"}
+
+var semanticTokenType = []int{
+	highlight.Comment:        0,
+	highlight.KeywordPart:    1,
+	highlight.Selector:       1,
+	highlight.Field:          2,
+	highlight.Argument:       3,
+	highlight.Local:          4,
+	highlight.Global:         5,
+	highlight.PseudoVariable: 6,
+	highlight.Number:         7,
+	highlight.String:         8,
+	highlight.Symbol:         9,
+	highlight.Keyword:        6,
+	highlight.Punctuation:    -1,
+	highlight.Variable:       4,
+	highlight.Illegal:        -1,
+}
+
+func (s *Server) semanticTokens(p SemanticTokensParams) *SemanticTokens {
+	f, ok := s.files[p.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+
+	semantic.Analyze(f.class, s)
+
+	tokens := &SemanticTokens{Data: []int{}}
+	var prev Position
+	for _, t := range highlight.Classify(f.text, f.class) {
+		if semanticTokenType[t.Category] < 0 {
+			continue
+		}
+
+		// Tokens can't span lines, so multi-line comments and strings are
+		// split into one token per line
+		for start := t.Pos.Offset; start < t.End.Offset; {
+			end := t.End.Offset
+			if i := strings.IndexByte(f.text[start:end], '\n'); i != -1 {
+				end = start + i
+			}
+
+			pos, endPos := f.position(start), f.position(end)
+			if end > start {
+				character := pos.Character
+				if pos.Line == prev.Line {
+					character -= prev.Character
+				}
+				tokens.Data = append(tokens.Data, pos.Line-prev.Line, character, endPos.Character-pos.Character, semanticTokenType[t.Category], 0)
+				prev = pos
+			}
+			start = end + 1
+		}
+	}
+
+	return tokens
+}
@@ -0,0 +1,438 @@
+// Package lsp implements a Language Server Protocol server for SOM, so that
+// editors can show diagnostics, navigate between senders and implementors,
+// and complete selectors.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/gtarcea/som/internal/ast"
+	"github.com/gtarcea/som/internal/diagnostic"
+	"github.com/gtarcea/som/internal/lexer"
+	"github.com/gtarcea/som/internal/parser"
+	"github.com/gtarcea/som/internal/semantic"
+	"github.com/gtarcea/som/internal/token"
+)
+
+// file is a .som file in the workspace or a document open in the editor,
+// which may not be saved yet.
+type file struct {
+	uri     string
+	text    string
+	version int
+	class   *ast.Class
+	err     error
+	// lines holds the offset of the start of each line.
+	lines []int
+}
+
+func newFile(uri, text string, version int) *file {
+	class, err := parser.NewWithMode(lexer.NewLexer(text), parser.Tolerant).Parse()
+	return makeFile(uri, text, version, class, err)
+}
+
+func makeFile(uri, text string, version int, class *ast.Class, err error) *file {
+	f := &file{uri: uri, text: text, version: version, class: class, err: err, lines: []int{0}}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			f.lines = append(f.lines, i+1)
+		}
+	}
+
+	return f
+}
+
+// edit returns the file with change applied. When f parsed cleanly only the
+// method the change is in, if any, is parsed again, the rest of the class is
+// taken over from f.
+func (f *file) edit(change TextDocumentContentChangeEvent, version int) *file {
+	if change.Range == nil {
+		return newFile(f.uri, change.Text, version)
+	}
+
+	edit := parser.Edit{Start: f.offset(change.Range.Start), End: f.offset(change.Range.End), Text: change.Text}
+	if edit.End < edit.Start {
+		edit.End = edit.Start
+	}
+	text := f.text[:edit.Start] + edit.Text + f.text[edit.End:]
+	if f.err != nil {
+		return newFile(f.uri, text, version)
+	}
+
+	class, err := parser.Reparse(f.class, text, edit, parser.Tolerant)
+	return makeFile(f.uri, text, version, class, err)
+}
+
+// position converts a byte offset to an LSP position, which counts characters
+// in UTF-16 code units.
+func (f *file) position(offset int) Position {
+	if offset > len(f.text) {
+		offset = len(f.text)
+	}
+
+	line := sort.Search(len(f.lines), func(i int) bool { return f.lines[i] > offset }) - 1
+	character := 0
+	for _, r := range f.text[f.lines[line]:offset] {
+		character += len(utf16.Encode([]rune{r}))
+	}
+
+	return Position{Line: line, Character: character}
+}
+
+// offset converts an LSP position to a byte offset.
+func (f *file) offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(f.lines) {
+		return len(f.text)
+	}
+
+	offset := f.lines[pos.Line]
+	for character := 0; character < pos.Character && offset < len(f.text) && f.text[offset] != '\n'; {
+		r, size := utf8.DecodeRuneInString(f.text[offset:])
+		character += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+
+	return offset
+}
+
+func (f *file) rangeOf(pos, end token.Position) Range {
+	return Range{Start: f.position(pos.Offset), End: f.position(end.Offset)}
+}
+
+func (f *file) location(pos, end token.Position) Location {
+	return Location{URI: f.uri, Range: f.rangeOf(pos, end)}
+}
+
+// Server is a language server talking to a single client.
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+	// files holds the files in the workspace by URI, open documents replacing
+	// their saved contents.
+	files map[string]*file
+	open  map[string]bool
+	// classes indexes files by the name of the class they define.
+	classes  map[string]*file
+	shutdown bool
+}
+
+// NewServer returns a server reading requests from in and writing responses
+// to out.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:      bufio.NewReader(in),
+		out:     out,
+		files:   make(map[string]*file),
+		open:    make(map[string]bool),
+		classes: make(map[string]*file),
+	}
+}
+
+// Run handles requests until the client sends exit or closes the connection.
+func (s *Server) Run() error {
+	for {
+		content, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil {
+			if err := s.reply(nil, nil, &responseError{Code: codeParseError, Message: err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if req.Method == "exit" {
+			return nil
+		}
+
+		result, err := s.handle(req.Method, req.Params)
+		if req.ID == nil {
+			// Nothing to reply to a notification, even when it failed
+			continue
+		}
+		if err := s.reply(req.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}, err error) error {
+	resp := response{JSONRPC: "2.0", ID: id}
+	if err != nil {
+		resp.Error, _ = err.(*responseError)
+		if resp.Error == nil {
+			resp.Error = &responseError{Code: codeInvalidRequest, Message: err.Error()}
+		}
+		return writeMessage(s.out, resp)
+	}
+
+	content, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	raw := json.RawMessage(content)
+	resp.Result = &raw
+
+	return writeMessage(s.out, resp)
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	return writeMessage(s.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (s *Server) handle(method string, params json.RawMessage) (interface{}, error) {
+	if s.shutdown && method != "exit" {
+		return nil, &responseError{Code: codeInvalidRequest, Message: "server is shut down"}
+	}
+
+	switch method {
+	case "initialize":
+		var p InitializeParams
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		s.loadWorkspace(p.RootURI)
+		return InitializeResult{Capabilities: ServerCapabilities{
+			TextDocumentSync:       SyncIncremental,
+			DefinitionProvider:     true,
+			ReferencesProvider:     true,
+			HoverProvider:          true,
+			DocumentSymbolProvider: true,
+			CompletionProvider:     &CompletionOptions{TriggerCharacters: []string{":"}},
+			SemanticTokensProvider: &SemanticTokensOptions{
+				Legend: SemanticTokensLegend{TokenTypes: semanticTokenTypes, TokenModifiers: []string{}},
+				Full:   true,
+			},
+		}}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var p DidOpenTextDocumentParams
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		s.open[p.TextDocument.URI] = true
+		s.update(newFile(p.TextDocument.URI, p.TextDocument.Text, p.TextDocument.Version))
+		return nil, nil
+	case "textDocument/didChange":
+		var p DidChangeTextDocumentParams
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		f, ok := s.files[p.TextDocument.URI]
+		if !ok {
+			f = newFile(p.TextDocument.URI, "", 0)
+		}
+		for _, change := range p.ContentChanges {
+			f = f.edit(change, p.TextDocument.Version)
+		}
+		s.update(f)
+		return nil, nil
+	case "textDocument/didClose":
+		var p DidCloseTextDocumentParams
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		s.close(p.TextDocument.URI)
+		return nil, nil
+	case "textDocument/definition":
+		var p TextDocumentPositionParams
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		return s.definition(p), nil
+	case "textDocument/references":
+		var p ReferenceParams
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		return s.references(p), nil
+	case "textDocument/hover":
+		var p TextDocumentPositionParams
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		return s.hover(p), nil
+	case "textDocument/documentSymbol":
+		var p DocumentSymbolParams
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		return s.documentSymbols(p), nil
+	case "textDocument/completion":
+		var p TextDocumentPositionParams
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		return s.completion(p), nil
+	case "textDocument/semanticTokens/full":
+		var p SemanticTokensParams
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		return s.semanticTokens(p), nil
+	}
+
+	return nil, &responseError{Code: codeMethodNotFound, Message: "method not supported: " + method}
+}
+
+func unmarshalParams(params json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+
+	return nil
+}
+
+// loadWorkspace reads every .som file under the directory rootURI.
+func (s *Server) loadWorkspace(rootURI string) {
+	root := uriToPath(rootURI)
+	if root == "" {
+		return
+	}
+
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() && path != root && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || filepath.Ext(path) != ".som" {
+			return nil
+		}
+
+		if text, err := ioutil.ReadFile(path); err == nil {
+			uri := pathToURI(path)
+			s.files[uri] = newFile(uri, string(text), 0)
+		}
+		return nil
+	})
+
+	s.indexClasses()
+}
+
+// update replaces a file and publishes diagnostics for every open document,
+// since a change to one class can change the diagnostics of its subclasses.
+func (s *Server) update(f *file) {
+	s.files[f.uri] = f
+	s.indexClasses()
+	s.publishDiagnostics()
+}
+
+// close drops an open document, going back to the saved file if there is one.
+func (s *Server) close(uri string) {
+	delete(s.open, uri)
+	delete(s.files, uri)
+	if text, err := ioutil.ReadFile(uriToPath(uri)); err == nil {
+		s.files[uri] = newFile(uri, string(text), 0)
+	}
+	s.indexClasses()
+
+	s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Diagnostics: []Diagnostic{}})
+	s.publishDiagnostics()
+}
+
+func (s *Server) indexClasses() {
+	s.classes = make(map[string]*file)
+	for _, f := range s.sortedFiles() {
+		if f.class.Name != nil {
+			s.classes[f.class.Name.Name] = f
+		}
+	}
+}
+
+// sortedFiles returns the files ordered by URI, so that results don't depend
+// on map order.
+func (s *Server) sortedFiles() []*file {
+	files := make([]*file, 0, len(s.files))
+	for _, f := range s.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].uri < files[j].uri })
+
+	return files
+}
+
+// LookupClass makes the workspace available to the semantic pass.
+func (s *Server) LookupClass(name string) *ast.Class {
+	if f, ok := s.classes[name]; ok {
+		return f.class
+	}
+
+	return nil
+}
+
+var _ semantic.Classes = (*Server)(nil)
+
+func (s *Server) publishDiagnostics() {
+	var uris []string
+	for uri := range s.open {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+
+	for _, uri := range uris {
+		f := s.files[uri]
+		diags := append(diagnostic.FromError(f.err), semantic.Analyze(f.class, s)...)
+
+		params := PublishDiagnosticsParams{URI: uri, Version: f.version, Diagnostics: []Diagnostic{}}
+		for _, d := range diags {
+			severity := SeverityError
+			if d.Severity == diagnostic.Warning {
+				severity = SeverityWarning
+			}
+			message := d.Message
+			if d.Hint != "" {
+				message += "\nhint: " + d.Hint
+			}
+			params.Diagnostics = append(params.Diagnostics, Diagnostic{
+				Range:    f.rangeOf(d.Pos, d.End),
+				Severity: severity,
+				Source:   "som",
+				Message:  message,
+			})
+		}
+
+		s.notify("textDocument/publishDiagnostics", params)
+	}
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+
+	return filepath.FromSlash(u.Path)
+}
+
+func pathToURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
@@ -0,0 +1,294 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const objectSource = `"The root of the class hierarchy"
+Object = nil (
+    | hash |
+
+    "Answers whether the receiver is nil"
+    isNil = ( ^false )
+
+    printOn: stream = ( "Prints the receiver" stream print: self )
+)
+`
+
+const counterSource = `Counter = Object (
+    | count |
+
+    increment = ( count := count + 1. ^self isNil )
+
+    add: n = ( [:x | | y | y := x + n. count := y ] value: n. ^hash )
+)
+`
+
+// session runs the server over a workspace holding Object.som and
+// Counter.som, sending it messages and collecting what it writes.
+type session struct {
+	t       *testing.T
+	dir     string
+	input   bytes.Buffer
+	nextID  int
+	results map[int]json.RawMessage
+	errors  map[int]*responseError
+	// diagnostics holds the last diagnostics published for each URI.
+	diagnostics map[string][]Diagnostic
+}
+
+func newSession(t *testing.T) *session {
+	dir, err := ioutil.TempDir("", "lsp")
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "Object.som"), []byte(objectSource), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "Counter.som"), []byte(counterSource), 0644))
+
+	s := &session{t: t, dir: dir}
+	s.request("initialize", InitializeParams{RootURI: pathToURI(dir)})
+	s.notify("initialized", struct{}{})
+	return s
+}
+
+func (s *session) uri(name string) string {
+	return pathToURI(filepath.Join(s.dir, name))
+}
+
+func (s *session) request(method string, params interface{}) int {
+	s.nextID++
+	content, err := json.Marshal(params)
+	require.NoError(s.t, err)
+	id := json.RawMessage(fmt.Sprint(s.nextID))
+	require.NoError(s.t, writeMessage(&s.input, request{JSONRPC: "2.0", ID: &id, Method: method, Params: content}))
+	return s.nextID
+}
+
+func (s *session) notify(method string, params interface{}) {
+	content, err := json.Marshal(params)
+	require.NoError(s.t, err)
+	require.NoError(s.t, writeMessage(&s.input, request{JSONRPC: "2.0", Method: method, Params: content}))
+}
+
+func (s *session) position(method, name string, line, character int) int {
+	return s.request(method, TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: s.uri(name)},
+		Position:     Position{Line: line, Character: character},
+	})
+}
+
+// run sends everything so far and reads back the replies.
+func (s *session) run() {
+	var output bytes.Buffer
+	require.NoError(s.t, NewServer(&s.input, &output).Run())
+
+	s.results = make(map[int]json.RawMessage)
+	s.errors = make(map[int]*responseError)
+	s.diagnostics = make(map[string][]Diagnostic)
+
+	r := bufio.NewReader(&output)
+	for {
+		content, err := readMessage(r)
+		if err != nil {
+			break
+		}
+
+		var msg struct {
+			ID     *int            `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+			Result json.RawMessage `json:"result"`
+			Error  *responseError  `json:"error"`
+		}
+		require.NoError(s.t, json.Unmarshal(content, &msg))
+
+		switch {
+		case msg.ID != nil && msg.Error != nil:
+			s.errors[*msg.ID] = msg.Error
+		case msg.ID != nil:
+			s.results[*msg.ID] = msg.Result
+		case msg.Method == "textDocument/publishDiagnostics":
+			var p PublishDiagnosticsParams
+			require.NoError(s.t, json.Unmarshal(msg.Params, &p))
+			s.diagnostics[p.URI] = p.Diagnostics
+		}
+	}
+}
+
+func (s *session) result(id int, v interface{}) {
+	require.Contains(s.t, s.results, id)
+	require.NoError(s.t, json.Unmarshal(s.results[id], v))
+}
+
+func TestServer(t *testing.T) {
+	s := newSession(t)
+	defer os.RemoveAll(s.dir)
+	s.notify("textDocument/didOpen", DidOpenTextDocumentParams{TextDocument: TextDocumentItem{
+		URI: s.uri("Counter.som"), LanguageID: "som", Version: 1, Text: counterSource,
+	}})
+
+	// isNil in increment
+	definition := s.position("textDocument/definition", "Counter.som", 3, 44)
+	hover := s.position("textDocument/hover", "Counter.som", 3, 44)
+	// n in add:, the argument of the method used inside the block
+	argDefinition := s.position("textDocument/definition", "Counter.som", 5, 36)
+	argReferences := s.request("textDocument/references", ReferenceParams{TextDocumentPositionParams: TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: s.uri("Counter.som")},
+		Position:     Position{Line: 5, Character: 9},
+	}})
+	// hash, inherited from Object
+	fieldDefinition := s.position("textDocument/definition", "Counter.som", 5, 64)
+	// Object in the header
+	classHover := s.position("textDocument/hover", "Counter.som", 0, 12)
+	// print: sent in Object
+	senders := s.request("textDocument/references", ReferenceParams{TextDocumentPositionParams: TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: s.uri("Object.som")},
+		Position:     Position{Line: 7, Character: 54},
+	}})
+	symbols := s.request("textDocument/documentSymbol", DocumentSymbolParams{TextDocument: TextDocumentIdentifier{URI: s.uri("Counter.som")}})
+	completion := s.position("textDocument/completion", "Counter.som", 3, 46)
+
+	s.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   VersionedTextDocumentIdentifier{URI: s.uri("Counter.som"), Version: 2},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "Counter = Object ( a = ( ^b + ) c = ( ^d ) )"}},
+	})
+	unknown := s.request("textDocument/formatting", struct{}{})
+	shutdown := s.request("shutdown", nil)
+	s.notify("exit", nil)
+	s.run()
+
+	var locations []Location
+	s.result(definition, &locations)
+	require.Equal(t, []Location{{URI: s.uri("Object.som"), Range: Range{Position{5, 4}, Position{5, 9}}}}, locations)
+
+	var h Hover
+	s.result(hover, &h)
+	require.Equal(t, "```som\nObject>>isNil\n```\n\nAnswers whether the receiver is nil", h.Contents.Value)
+
+	s.result(argDefinition, &locations)
+	require.Equal(t, []Location{{URI: s.uri("Counter.som"), Range: Range{Position{5, 9}, Position{5, 10}}}}, locations)
+
+	s.result(argReferences, &locations)
+	require.Equal(t, []Location{
+		{URI: s.uri("Counter.som"), Range: Range{Position{5, 36}, Position{5, 37}}},
+		{URI: s.uri("Counter.som"), Range: Range{Position{5, 59}, Position{5, 60}}},
+	}, locations)
+
+	s.result(fieldDefinition, &locations)
+	require.Equal(t, []Location{{URI: s.uri("Object.som"), Range: Range{Position{2, 6}, Position{2, 10}}}}, locations)
+
+	s.result(classHover, &h)
+	require.Equal(t, "```som\nObject = nil\n```\n\nThe root of the class hierarchy", h.Contents.Value)
+
+	s.result(senders, &locations)
+	require.Equal(t, []Location{{URI: s.uri("Object.som"), Range: Range{Position{7, 53}, Position{7, 59}}}}, locations)
+
+	var syms []DocumentSymbol
+	s.result(symbols, &syms)
+	require.Len(t, syms, 1)
+	require.Equal(t, "Counter", syms[0].Name)
+	var children []string
+	for _, child := range syms[0].Children {
+		children = append(children, child.Name)
+	}
+	require.Equal(t, []string{"count", "increment", "add:"}, children)
+
+	var items []CompletionItem
+	s.result(completion, &items)
+	var labels []string
+	for _, item := range items {
+		labels = append(labels, item.Label)
+	}
+	require.Equal(t, []string{"isNil"}, labels)
+
+	// The diagnostics from the last change replace the earlier ones
+	require.Equal(t, []Diagnostic{{
+		Range:    Range{Position{0, 30}, Position{0, 31}},
+		Severity: SeverityError,
+		Source:   "som",
+		Message:  `expected expression, found ")"`,
+	}, {
+		Range:    Range{Position{0, 39}, Position{0, 40}},
+		Severity: SeverityError,
+		Source:   "som",
+		Message:  "undefined variable d",
+	}}, s.diagnostics[s.uri("Counter.som")])
+
+	require.Equal(t, codeMethodNotFound, s.errors[unknown].Code)
+	require.Contains(t, s.results, shutdown)
+}
+
+func TestIncrementalChange(t *testing.T) {
+	s := newSession(t)
+	defer os.RemoveAll(s.dir)
+	s.notify("textDocument/didOpen", DidOpenTextDocumentParams{TextDocument: TextDocumentItem{
+		URI: s.uri("Counter.som"), LanguageID: "som", Version: 1, Text: counterSource,
+	}})
+
+	// Replace the 1 in increment
+	s.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument: VersionedTextDocumentIdentifier{URI: s.uri("Counter.som"), Version: 2},
+		ContentChanges: []TextDocumentContentChangeEvent{{
+			Range: &Range{Position{3, 35}, Position{3, 36}},
+			Text:  "zork",
+		}},
+	})
+	// n in add:, after the edited method
+	definition := s.position("textDocument/definition", "Counter.som", 5, 36)
+	s.run()
+
+	require.Equal(t, []Diagnostic{{
+		Range:    Range{Position{3, 35}, Position{3, 39}},
+		Severity: SeverityError,
+		Source:   "som",
+		Message:  "undefined variable zork",
+	}}, s.diagnostics[s.uri("Counter.som")])
+
+	var locations []Location
+	s.result(definition, &locations)
+	require.Equal(t, []Location{{URI: s.uri("Counter.som"), Range: Range{Position{5, 9}, Position{5, 10}}}}, locations)
+}
+
+func TestSemanticTokens(t *testing.T) {
+	s := newSession(t)
+	defer os.RemoveAll(s.dir)
+	tokens := s.request("textDocument/semanticTokens/full", SemanticTokensParams{TextDocument: TextDocumentIdentifier{URI: s.uri("Object.som")}})
+	s.run()
+
+	var result SemanticTokens
+	s.result(tokens, &result)
+	require.Equal(t, []int{
+		0, 0, 33, 0, 0, // "The root of the class hierarchy"
+		1, 0, 6, 5, 0, // Object
+		0, 9, 3, 6, 0, // nil
+		1, 6, 4, 2, 0, // hash
+	}, result.Data[:20])
+}
+
+func TestPositions(t *testing.T) {
+	f := newFile("file:///a.som", "a\n\"é😀\" b", 0)
+
+	tests := []struct {
+		offset   int
+		position Position
+	}{
+		{0, Position{0, 0}},
+		{2, Position{1, 0}},
+		{5, Position{1, 2}},
+		{9, Position{1, 4}},
+		{12, Position{1, 7}},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.position, f.position(test.offset))
+		require.Equal(t, test.offset, f.offset(test.position))
+	}
+}
@@ -1,21 +1,73 @@
 package parser
 
 import (
+	"fmt"
+	"strings"
+
+	"github.com/gtarcea/som/internal/ast"
+	"github.com/gtarcea/som/internal/diagnostic"
 	"github.com/gtarcea/som/internal/lexer"
 	"github.com/gtarcea/som/internal/token"
 	"github.com/hashicorp/go-multierror"
 )
 
+// Error is a syntax error. Expected lists the token types that would have been
+// accepted at Pos, Hint is an optional suggestion for fixing the error.
+type Error struct {
+	Pos      token.Position
+	End      token.Position
+	Expected []token.Type
+	Msg      string
+	Hint     string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Pos.Line, e.Pos.Column, e.Msg)
+}
+
+func (e *Error) Diagnostic() diagnostic.Diagnostic {
+	return diagnostic.Diagnostic{
+		Severity: diagnostic.Error,
+		Pos:      e.Pos,
+		End:      e.End,
+		Message:  e.Msg,
+		Hint:     e.Hint,
+	}
+}
+
+// Mode controls optional parser behavior.
+type Mode uint
+
+const (
+	// Tolerant keeps going after a syntax error in a statement, replacing it
+	// with an ast.BadExpr and parsing the statements after it, and keeps
+	// blocks that are missing their closing bracket. It suits editors, which
+	// see incomplete code all the time and want as much of it as possible.
+	Tolerant Mode = 1 << iota
+)
+
 type Parser struct {
 	l            *lexer.Lexer
+	mode         Mode
 	errors       multierror.Error
+	prevToken    token.Token
 	currentToken token.Token
 	peekToken    token.Token
+	comments     []token.Token
+
+	// nesting counts the parentheses and brackets opened and not yet closed in
+	// the method being parsed, so that synchronize knows where the method ends.
+	nesting int
 }
 
 func New(l *lexer.Lexer) *Parser {
+	return NewWithMode(l, 0)
+}
+
+func NewWithMode(l *lexer.Lexer, mode Mode) *Parser {
 	p := &Parser{
-		l: l,
+		l:    l,
+		mode: mode,
 	}
 
 	p.nextToken()
@@ -25,10 +77,879 @@ func New(l *lexer.Lexer) *Parser {
 }
 
 func (p *Parser) nextToken() {
+	p.prevToken = p.currentToken
 	p.currentToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	for p.peekToken.Type == token.COMMENT {
+		p.comments = append(p.comments, p.peekToken)
+		p.peekToken = p.l.NextToken()
+	}
+}
+
+// Parse parses a class definition. When the source has syntax errors the
+// parser carries on with the next method after each one, so the returned error
+// is a *multierror.Error holding an *Error for each problem found and the
+// returned class holds everything that could be parsed.
+func (p *Parser) Parse() (*ast.Class, error) {
+	class := p.parseClass()
+	if !p.currentTokenIs(token.EOF) {
+		p.unexpected("end of file", token.EOF)
+	}
+	class.Comments = p.comments
+
+	return class, p.errors.ErrorOrNil()
+}
+
+func (p *Parser) parseClass() *ast.Class {
+	class := &ast.Class{Span: ast.Span{Start: p.currentToken.Pos}}
+
+	if !p.parseClassHeader(class) {
+		p.synchronizeHeader()
+	}
+
+	class.InstanceFields, class.InstanceFieldsBar = p.parseVariables()
+	class.InstanceMethods = p.parseMethods(false)
+
+	if p.currentTokenIs(token.SEPARATOR) {
+		p.nextToken()
+		class.ClassFields, class.ClassFieldsBar = p.parseVariables()
+		class.ClassMethods = p.parseMethods(true)
+	}
+
+	p.expect(token.ENDTERM)
+	class.Stop = p.prevToken.End
+
+	return class
+}
+
+// parseClassHeader parses Name = Superclass ( with the superclass being optional.
+func (p *Parser) parseClassHeader(class *ast.Class) bool {
+	if !p.currentTokenIs(token.IDENTIFIER) {
+		p.unexpected("class name", token.IDENTIFIER)
+		return false
+	}
+	class.Name = p.parseIdent()
+
+	if !p.expect(token.EQUAL) {
+		return false
+	}
+
+	if p.currentTokenIs(token.IDENTIFIER) {
+		class.Superclass = p.parseIdent()
+	}
+
+	return p.expect(token.NEWTERM)
+}
+
+// synchronizeHeader skips a broken class header up to the start of the class
+// body.
+func (p *Parser) synchronizeHeader() {
+	for !p.currentTokenIs(token.EOF) {
+		switch {
+		case p.currentTokenIs(token.NEWTERM):
+			p.nextToken()
+			return
+		case p.currentTokenIs(token.OR), p.atMethodStart():
+			return
+		}
+		p.nextToken()
+	}
+}
+
+// parseVariables parses an optional | a b c | declaration, returning the
+// position of the closing | along with the variables.
+func (p *Parser) parseVariables() ([]*ast.Ident, token.Position) {
+	var vars []*ast.Ident
+
+	if p.currentTokenIs(token.OPERATOR_SEQUENCE) && p.currentToken.Literal == "||" {
+		p.nextToken()
+		return vars, p.prevToken.Pos
+	}
+
+	if !p.currentTokenIs(token.OR) {
+		return vars, token.Position{}
+	}
+
+	p.nextToken()
+	for p.currentTokenIs(token.IDENTIFIER) {
+		vars = append(vars, p.parseIdent())
+	}
+	bar := p.currentToken.Pos
+	if !p.expect(token.OR) {
+		return vars, token.Position{}
+	}
+
+	return vars, bar
 }
 
-func (p *Parser) Parse() {
-	return
+func (p *Parser) parseMethods(classSide bool) []*ast.Method {
+	var methods []*ast.Method
+
+	for !p.currentTokenIs(token.ENDTERM) && !p.currentTokenIs(token.SEPARATOR) && !p.currentTokenIs(token.EOF) {
+		start := p.currentToken.Pos
+		method, ok := p.parseMethod()
+		if method != nil {
+			method.ClassSide = classSide
+			methods = append(methods, method)
+		}
+
+		if !ok {
+			p.synchronize()
+			if p.currentToken.Pos == start {
+				// Nothing was consumed, skip the offending token so we make progress.
+				p.nextToken()
+			}
+		}
+	}
+
+	return methods
+}
+
+// parseMethod parses pattern = primitive or pattern = ( | locals | body ).
+// On a syntax error it returns false along with the method if its pattern
+// could be parsed.
+func (p *Parser) parseMethod() (*ast.Method, bool) {
+	p.nesting = 0
+	method := &ast.Method{Span: ast.Span{Start: p.currentToken.Pos}}
+
+	if !p.parsePattern(method) {
+		return nil, false
+	}
+
+	if !p.expect(token.EQUAL) {
+		return nil, false
+	}
+
+	method.Open = p.currentToken.Pos
+	if p.currentTokenIs(token.PRIMITIVE) {
+		method.Primitive = true
+		method.Stop = p.currentToken.End
+		p.nextToken()
+		return method, true
+	}
+
+	if !p.expectOpen(token.NEWTERM) {
+		return nil, false
+	}
+
+	method.Locals, method.LocalsBar = p.parseVariables()
+	body, ok := p.parseBody(token.ENDTERM)
+	method.Body = body
+	if ok {
+		ok = p.expectClose(token.ENDTERM)
+	}
+	method.Stop = p.prevToken.End
+
+	return method, ok
+}
+
+func (p *Parser) parsePattern(method *ast.Method) bool {
+	switch {
+	case p.currentTokenIs(token.IDENTIFIER):
+		method.Selector = p.currentToken.Literal
+		method.Parts = []token.Token{p.currentToken}
+		p.nextToken()
+	case isBinarySelector(p.currentToken):
+		method.Selector = p.currentToken.Literal
+		method.Parts = []token.Token{p.currentToken}
+		p.nextToken()
+		if !p.currentTokenIs(token.IDENTIFIER) {
+			p.unexpected("argument name", token.IDENTIFIER)
+			return false
+		}
+		method.Arguments = []*ast.Ident{p.parseIdent()}
+	case p.currentTokenIs(token.KEYWORD):
+		var b strings.Builder
+		for p.currentTokenIs(token.KEYWORD) {
+			b.WriteString(p.currentToken.Literal)
+			method.Parts = append(method.Parts, p.currentToken)
+			p.nextToken()
+			if !p.currentTokenIs(token.IDENTIFIER) {
+				p.unexpected("argument name", token.IDENTIFIER)
+				return false
+			}
+			method.Arguments = append(method.Arguments, p.parseIdent())
+		}
+		method.Selector = b.String()
+	default:
+		p.unexpected("method pattern", token.IDENTIFIER, token.KEYWORD, token.OPERATOR_SEQUENCE)
+		return false
+	}
+
+	return true
+}
+
+// parseBody parses the statements of a method or block up to, but not
+// including, the end token.
+func (p *Parser) parseBody(end token.Type) ([]ast.Expression, bool) {
+	var body []ast.Expression
+	nesting := p.nesting
+
+	for !p.currentTokenIs(end) {
+		start := p.currentToken.Pos
+
+		var expr ast.Expression
+		if p.currentTokenIs(token.EXIT) {
+			expr = p.parseReturn()
+		} else {
+			expr = p.parseExpression()
+		}
+		if expr == nil {
+			if p.mode&Tolerant == 0 {
+				return body, false
+			}
+			stop, ok := p.skipStatement(nesting, end)
+			body = append(body, badExpr(start, stop))
+			if !ok {
+				return body, false
+			}
+			continue
+		}
+		body = append(body, expr)
+
+		// A return ends the body
+		_, returned := expr.(*ast.Return)
+		if p.currentTokenIs(token.PERIOD) {
+			p.nextToken()
+			if !returned {
+				continue
+			}
+		}
+
+		if !p.currentTokenIs(end) {
+			var err *Error
+			if returned {
+				err = p.unexpected(describeType(end)+" after return", end)
+			} else {
+				err = p.unexpected(describeType(token.PERIOD)+" or "+describeType(end), token.PERIOD, end)
+			}
+			if err != nil && err.Hint == "" {
+				err.Hint = p.missingColonHint(expr)
+				closing := p.currentTokenIs(token.ENDTERM) || p.currentTokenIs(token.ENDBLOCK)
+				if err.Hint == "" && returned && p.prevToken.Type == token.PERIOD && !closing {
+					err.Hint = "statements after a return are unreachable"
+				}
+			}
+
+			if p.mode&Tolerant == 0 {
+				return body, false
+			}
+			start := p.currentToken.Pos
+			stop, ok := p.skipStatement(nesting, end)
+			if stop.Offset > start.Offset {
+				body = append(body, badExpr(start, stop))
+			}
+			if !ok {
+				return body, false
+			}
+		}
+	}
+
+	return body, true
+}
+
+// skipStatement skips the rest of a broken statement in tolerant mode, up to
+// and including the period ending it or up to the end token of the body,
+// keeping track of the parentheses and brackets opened since the body started
+// at the given nesting. It returns false if the body isn't closed, because the
+// next method starts or a closing parenthesis or bracket belongs to an
+// enclosing body. It also returns the end of the last token of the statement.
+func (p *Parser) skipStatement(nesting int, end token.Type) (token.Position, bool) {
+	depth := p.nesting - nesting
+	defer func() { p.nesting = nesting }()
+
+	stop := p.prevToken.End
+	for !p.currentTokenIs(token.EOF) && !p.currentTokenIs(token.SEPARATOR) {
+		switch {
+		case p.currentTokenIs(token.NEWTERM), p.currentTokenIs(token.NEWBLOCK):
+			depth++
+		case p.currentTokenIs(token.ENDTERM), p.currentTokenIs(token.ENDBLOCK):
+			if depth == 0 {
+				return stop, p.currentTokenIs(end)
+			}
+			depth--
+		case p.currentTokenIs(token.PERIOD) && depth == 0:
+			p.nextToken()
+			return stop, true
+		case p.currentTokenIs(token.ILLEGAL):
+			p.unexpected("")
+		case p.atMethodStart():
+			return stop, false
+		}
+
+		stop = p.currentToken.End
+		p.nextToken()
+	}
+
+	return stop, false
+}
+
+// badExpr returns a BadExpr for the statement from start to stop, which is
+// empty if the statement broke at its first token.
+func badExpr(start, stop token.Position) *ast.BadExpr {
+	if stop.Offset < start.Offset {
+		stop = start
+	}
+
+	return &ast.BadExpr{Span: ast.Span{Start: start, Stop: stop}}
+}
+
+func (p *Parser) parseReturn() ast.Expression {
+	start := p.currentToken.Pos
+	p.nextToken()
+
+	value := p.parseExpression()
+	if value == nil {
+		return nil
+	}
+
+	return &ast.Return{Span: ast.Span{Start: start, Stop: value.End()}, Value: value}
+}
+
+func (p *Parser) parseExpression() ast.Expression {
+	if p.currentTokenIs(token.IDENTIFIER) && p.peekTokenIs(token.ASSIGN) {
+		variable := p.parseVariable()
+		p.nextToken()
+
+		value := p.parseExpression()
+		if value == nil {
+			return nil
+		}
+
+		return &ast.Assignment{
+			Span:     ast.Span{Start: variable.Pos(), Stop: value.End()},
+			Variable: variable,
+			Value:    value,
+		}
+	}
+
+	primary := p.parsePrimary()
+	if primary == nil {
+		return nil
+	}
+
+	return p.parseMessages(primary)
+}
+
+func (p *Parser) parsePrimary() ast.Expression {
+	switch {
+	case p.currentTokenIs(token.IDENTIFIER):
+		return p.parseVariable()
+	case p.currentTokenIs(token.NEWTERM):
+		p.expectOpen(token.NEWTERM)
+		expr := p.parseExpression()
+		if expr == nil || !p.expectClose(token.ENDTERM) {
+			return nil
+		}
+		return expr
+	case p.currentTokenIs(token.NEWBLOCK):
+		return p.parseBlock()
+	default:
+		if lit := p.parseLiteral(); lit != nil {
+			return lit
+		}
+		return nil
+	}
+}
+
+// parseLiteral parses a number, string, symbol or array literal, reporting an
+// error if the current token doesn't start one.
+func (p *Parser) parseLiteral() ast.Expression {
+	start := p.currentToken.Pos
+
+	switch {
+	case p.currentTokenIs(token.INTEGER), p.currentTokenIs(token.DOUBLE):
+		return p.parseNumber("", start)
+	case p.currentTokenIs(token.MINUS) && (p.peekTokenIs(token.INTEGER) || p.peekTokenIs(token.DOUBLE)):
+		p.nextToken()
+		return p.parseNumber("-", start)
+	case p.currentTokenIs(token.STRING):
+		lit := &ast.Literal{
+			Span:  p.span(),
+			Kind:  ast.StringLiteral,
+			Value: unquote(p.currentToken.Literal),
+			Raw:   p.currentToken.Literal,
+		}
+		p.nextToken()
+		return lit
+	case p.currentTokenIs(token.POUND):
+		p.nextToken()
+		if p.currentTokenIs(token.NEWTERM) {
+			return p.parseArray(start)
+		}
+		return p.parseSymbol(start)
+	}
+
+	p.unexpected("expression", token.IDENTIFIER, token.NEWTERM, token.NEWBLOCK, token.INTEGER, token.DOUBLE, token.STRING, token.POUND)
+	return nil
+}
+
+func (p *Parser) parseNumber(sign string, start token.Position) ast.Expression {
+	kind := ast.IntegerLiteral
+	if p.currentTokenIs(token.DOUBLE) {
+		kind = ast.DoubleLiteral
+	}
+
+	lit := &ast.Literal{
+		Span:  ast.Span{Start: start, Stop: p.currentToken.End},
+		Kind:  kind,
+		Value: sign + p.currentToken.Literal,
+		Raw:   sign + p.currentToken.Literal,
+	}
+	p.nextToken()
+
+	return lit
+}
+
+// parseSymbol parses the part of #foo, #foo:bar:, #+ or #'foo' after the #.
+func (p *Parser) parseSymbol(start token.Position) ast.Expression {
+	var value string
+
+	switch {
+	case p.currentTokenIs(token.IDENTIFIER), p.currentTokenIs(token.KEYWORD), p.currentTokenIs(token.KEYWORD_SEQUENCE),
+		p.currentTokenIs(token.PRIMITIVE), isBinarySelector(p.currentToken):
+		value = p.currentToken.Literal
+	case p.currentTokenIs(token.STRING):
+		value = unquote(p.currentToken.Literal)
+	default:
+		p.unexpected("symbol", token.IDENTIFIER, token.KEYWORD, token.KEYWORD_SEQUENCE, token.STRING, token.NEWTERM)
+		return nil
+	}
+
+	lit := &ast.Literal{
+		Span:  ast.Span{Start: start, Stop: p.currentToken.End},
+		Kind:  ast.SymbolLiteral,
+		Value: value,
+		Raw:   "#" + p.currentToken.Literal,
+	}
+	p.nextToken()
+
+	return lit
+}
+
+// parseArray parses the part of #(1 2 #foo) after the #. Nested arrays may
+// leave off the #, and bare names inside an array are symbols.
+func (p *Parser) parseArray(start token.Position) ast.Expression {
+	p.expectOpen(token.NEWTERM)
+
+	array := &ast.ArrayLiteral{Span: ast.Span{Start: start}}
+	for !p.currentTokenIs(token.ENDTERM) {
+		var element ast.Expression
+
+		switch {
+		case p.mode&Tolerant != 0 && p.atMethodStart():
+			// The array is left open, the next method is not part of it
+			p.unexpected(describeType(token.ENDTERM), token.ENDTERM)
+		case p.currentTokenIs(token.NEWTERM):
+			element = p.parseArray(p.currentToken.Pos)
+		case p.currentTokenIs(token.IDENTIFIER), p.currentTokenIs(token.KEYWORD), p.currentTokenIs(token.KEYWORD_SEQUENCE):
+			element = &ast.Literal{
+				Span:  p.span(),
+				Kind:  ast.SymbolLiteral,
+				Value: p.currentToken.Literal,
+				Raw:   p.currentToken.Literal,
+			}
+			p.nextToken()
+		default:
+			element = p.parseLiteral()
+		}
+
+		if element == nil {
+			return nil
+		}
+		array.Elements = append(array.Elements, element)
+	}
+
+	p.expectClose(token.ENDTERM)
+	array.Stop = p.prevToken.End
+
+	return array
+}
+
+func (p *Parser) parseBlock() ast.Expression {
+	block := &ast.Block{Span: ast.Span{Start: p.currentToken.Pos}}
+	p.expectOpen(token.NEWBLOCK)
+
+	for p.currentTokenIs(token.COLON) {
+		p.nextToken()
+		if !p.currentTokenIs(token.IDENTIFIER) {
+			p.unexpected("block parameter name", token.IDENTIFIER)
+			return nil
+		}
+		block.Parameters = append(block.Parameters, p.parseIdent())
+	}
+
+	if len(block.Parameters) > 0 {
+		block.ParametersBar = p.currentToken.Pos
+		if p.currentTokenIs(token.OPERATOR_SEQUENCE) && p.currentToken.Literal == "||" {
+			// [:a || b | ...] the parameter bar runs into the locals bar
+			p.currentToken.Type = token.OR
+		} else if !p.expect(token.OR) {
+			return nil
+		}
+	}
+
+	block.Locals, block.LocalsBar = p.parseVariables()
+	body, ok := p.parseBody(token.ENDBLOCK)
+	if !ok || !p.expectClose(token.ENDBLOCK) {
+		if p.mode&Tolerant == 0 || !p.currentTokenIs(token.ENDTERM) {
+			return nil
+		}
+		// The block runs into the end of the method or parentheses around it,
+		// take it as closed there.
+		p.nesting--
+	}
+	block.Body = body
+	block.Stop = p.prevToken.End
+
+	return block
+}
+
+// parseMessages parses the messages sent to receiver, unary messages bind
+// tightest, then binary and then keyword.
+func (p *Parser) parseMessages(receiver ast.Expression) ast.Expression {
+	receiver = p.parseUnaryMessages(receiver)
+	receiver = p.parseBinaryMessages(receiver)
+	if receiver == nil || !p.currentTokenIs(token.KEYWORD) {
+		return receiver
+	}
+
+	send := &ast.Send{Span: ast.Span{Start: receiver.Pos()}, Receiver: receiver}
+	var b strings.Builder
+	for p.currentTokenIs(token.KEYWORD) {
+		b.WriteString(p.currentToken.Literal)
+		send.Parts = append(send.Parts, p.currentToken)
+		p.nextToken()
+
+		arg := p.parseArgument()
+		arg = p.parseUnaryMessages(arg)
+		arg = p.parseBinaryMessages(arg)
+		if arg == nil {
+			return nil
+		}
+		send.Arguments = append(send.Arguments, arg)
+	}
+	send.Selector = b.String()
+	send.Stop = p.prevToken.End
+
+	return send
+}
+
+// parseArgument parses the primary a message argument starts with. In tolerant
+// mode an argument missing at the end of a line isn't taken from the method
+// that starts on the next one.
+func (p *Parser) parseArgument() ast.Expression {
+	if p.mode&Tolerant != 0 && p.atMethodStart() {
+		p.unexpected("expression", token.IDENTIFIER, token.NEWTERM, token.NEWBLOCK, token.INTEGER, token.DOUBLE, token.STRING, token.POUND)
+		return nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *Parser) parseUnaryMessages(receiver ast.Expression) ast.Expression {
+	for receiver != nil && p.currentTokenIs(token.IDENTIFIER) && !p.peekTokenIs(token.ASSIGN) {
+		receiver = &ast.Send{
+			Span:     ast.Span{Start: receiver.Pos(), Stop: p.currentToken.End},
+			Receiver: receiver,
+			Selector: p.currentToken.Literal,
+			Parts:    []token.Token{p.currentToken},
+		}
+		p.nextToken()
+	}
+
+	return receiver
+}
+
+func (p *Parser) parseBinaryMessages(receiver ast.Expression) ast.Expression {
+	for receiver != nil && isBinarySelector(p.currentToken) {
+		selector := p.currentToken
+		p.nextToken()
+
+		arg := p.parseUnaryMessages(p.parseArgument())
+		if arg == nil {
+			return nil
+		}
+
+		receiver = &ast.Send{
+			Span:      ast.Span{Start: receiver.Pos(), Stop: arg.End()},
+			Receiver:  receiver,
+			Selector:  selector.Literal,
+			Parts:     []token.Token{selector},
+			Arguments: []ast.Expression{arg},
+		}
+	}
+
+	return receiver
+}
+
+func (p *Parser) parseVariable() *ast.Variable {
+	v := &ast.Variable{Span: p.span(), Name: p.currentToken.Literal}
+	p.nextToken()
+	return v
+}
+
+func (p *Parser) parseIdent() *ast.Ident {
+	ident := &ast.Ident{Span: p.span(), Name: p.currentToken.Literal}
+	p.nextToken()
+	return ident
+}
+
+// span is the span of the current token.
+func (p *Parser) span() ast.Span {
+	return ast.Span{Start: p.currentToken.Pos, Stop: p.currentToken.End}
+}
+
+func (p *Parser) currentTokenIs(t token.Type) bool {
+	return p.currentToken.Type == t
+}
+
+func (p *Parser) peekTokenIs(t token.Type) bool {
+	return p.peekToken.Type == t
+}
+
+// expect consumes the current token if it is of type t, otherwise it reports
+// an error and leaves the token alone.
+func (p *Parser) expect(t token.Type) bool {
+	if !p.currentTokenIs(t) {
+		p.unexpected(describeType(t), t)
+		return false
+	}
+
+	p.nextToken()
+	return true
+}
+
+func (p *Parser) expectOpen(t token.Type) bool {
+	if !p.expect(t) {
+		return false
+	}
+
+	p.nesting++
+	return true
+}
+
+func (p *Parser) expectClose(t token.Type) bool {
+	if !p.expect(t) {
+		return false
+	}
+
+	p.nesting--
+	return true
+}
+
+// unexpected reports that the current token isn't what was wanted. It returns
+// the error so a hint can be added, or nil if the error wasn't reported.
+func (p *Parser) unexpected(wanted string, expected ...token.Type) *Error {
+	var msg string
+
+	switch {
+	case p.currentTokenIs(token.ILLEGAL) && strings.HasPrefix(p.currentToken.Literal, "'"):
+		msg = "unterminated string"
+	case p.currentTokenIs(token.ILLEGAL) && strings.HasPrefix(p.currentToken.Literal, `"`):
+		msg = "unterminated comment"
+	case p.currentTokenIs(token.ILLEGAL):
+		msg = fmt.Sprintf("illegal character %q", p.currentToken.Literal)
+	default:
+		msg = fmt.Sprintf("expected %s, found %s", wanted, describeToken(p.currentToken))
+	}
+
+	if n := len(p.errors.Errors); n > 0 {
+		last := p.errors.Errors[n-1].(*Error)
+		if last.Pos == p.currentToken.Pos || last.End == p.currentToken.Pos && p.currentTokenIs(token.EOF) {
+			// Already reported a problem here, or an unterminated string or
+			// comment ran to the end of the file.
+			return nil
+		}
+	}
+
+	err := &Error{
+		Pos:      p.currentToken.Pos,
+		End:      p.currentToken.End,
+		Expected: expected,
+		Msg:      msg,
+	}
+	if p.currentTokenIs(token.KEYWORD_SEQUENCE) {
+		// at:key lexes as one token, as it would in #at:put:
+		i := strings.IndexByte(p.currentToken.Literal, ':') + 1
+		err.Hint = fmt.Sprintf("add a space after `%s` to pass `%s` as its argument",
+			p.currentToken.Literal[:i], p.currentToken.Literal[i:])
+	}
+	multierror.Append(&p.errors, err)
+
+	return err
+}
+
+// missingColonHint suggests a keyword selector when expr ends in a unary send
+// and is followed by something that looks like an argument, as in
+// x ifTrue: [...] ifFalse [...] where the parser stops at the second block.
+func (p *Parser) missingColonHint(expr ast.Expression) string {
+	switch p.currentToken.Type {
+	case token.NEWBLOCK, token.NEWTERM, token.INTEGER, token.DOUBLE, token.STRING, token.POUND:
+	default:
+		return ""
+	}
+
+	keyword := ""
+	for {
+		switch e := expr.(type) {
+		case *ast.Return:
+			expr = e.Value
+		case *ast.Assignment:
+			expr = e.Value
+		case *ast.Send:
+			switch {
+			case len(e.Arguments) == 0:
+				return fmt.Sprintf("did you mean `%s%s:`?", keyword, e.Selector)
+			case strings.HasSuffix(e.Selector, ":"):
+				keyword = e.Selector
+				expr = e.Arguments[len(e.Arguments)-1]
+			default:
+				return ""
+			}
+		default:
+			return ""
+		}
+	}
+}
+
+// synchronize skips the rest of a method after a syntax error. It stops after
+// the closing parenthesis of the method, or at the start of the next method or
+// the class side separator when the parentheses in the method don't balance.
+func (p *Parser) synchronize() {
+	depth := p.nesting
+
+	for !p.currentTokenIs(token.EOF) && !p.currentTokenIs(token.SEPARATOR) {
+		switch {
+		case p.currentTokenIs(token.NEWTERM), p.currentTokenIs(token.NEWBLOCK):
+			depth++
+		case p.currentTokenIs(token.ENDTERM), p.currentTokenIs(token.ENDBLOCK):
+			if depth == 0 {
+				// Closes the class
+				return
+			}
+
+			depth--
+			if depth == 0 {
+				p.nextToken()
+				return
+			}
+		case p.currentTokenIs(token.ILLEGAL):
+			// Lexical errors are worth reporting even in code we are skipping
+			p.unexpected("")
+		case p.atMethodStart():
+			return
+		}
+
+		p.nextToken()
+	}
+}
+
+// atMethodStart reports whether the current token starts a line and begins a
+// method definition. It looks ahead on a copy of the lexer, so no tokens are
+// consumed.
+func (p *Parser) atMethodStart() bool {
+	if p.prevToken.Type != "" && p.currentToken.Pos.Line == p.prevToken.End.Line {
+		return false
+	}
+
+	l := *p.l
+	tokens := []token.Token{p.currentToken, p.peekToken}
+	tokenAt := func(i int) token.Token {
+		for len(tokens) <= i {
+			if t := l.NextToken(); t.Type != token.COMMENT {
+				tokens = append(tokens, t)
+			}
+		}
+		return tokens[i]
+	}
+
+	i := 0
+	switch {
+	case tokenAt(0).Type == token.IDENTIFIER:
+		i = 1
+	case isBinarySelector(tokenAt(0)):
+		if tokenAt(1).Type != token.IDENTIFIER {
+			return false
+		}
+		i = 2
+	case tokenAt(0).Type == token.KEYWORD:
+		for tokenAt(i).Type == token.KEYWORD {
+			if tokenAt(i+1).Type != token.IDENTIFIER {
+				return false
+			}
+			i += 2
+		}
+	default:
+		return false
+	}
+
+	return tokenAt(i).Type == token.EQUAL && (tokenAt(i+1).Type == token.NEWTERM || tokenAt(i+1).Type == token.PRIMITIVE)
+}
+
+func isBinarySelector(t token.Token) bool {
+	switch t.Type {
+	case token.OR, token.NOT, token.AND, token.MULT, token.DIV, token.MOD, token.PLUS, token.MORE, token.LESS,
+		token.AT, token.PERCENT, token.COMMA, token.EQUAL, token.MINUS, token.OPERATOR_SEQUENCE:
+		return true
+	}
+
+	return false
+}
+
+// unquote strips the quotes from a string literal and replaces its escape
+// sequences. Escaping any other character, such as \' or \\, stands for the
+// character itself.
+func unquote(literal string) string {
+	var b strings.Builder
+
+	s := literal[1 : len(literal)-1]
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'b':
+			b.WriteByte('\b')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case '0':
+			b.WriteByte(0)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String()
+}
+
+func describeType(t token.Type) string {
+	switch t {
+	case token.IDENTIFIER, token.KEYWORD, token.KEYWORD_SEQUENCE, token.INTEGER, token.DOUBLE, token.STRING,
+		token.OPERATOR_SEQUENCE, token.PRIMITIVE:
+		return string(t)
+	case token.EOF:
+		return "end of file"
+	}
+
+	return fmt.Sprintf("%q", string(t))
+}
+
+func describeToken(t token.Token) string {
+	switch t.Type {
+	case token.IDENTIFIER, token.KEYWORD, token.KEYWORD_SEQUENCE, token.INTEGER, token.DOUBLE, token.STRING,
+		token.OPERATOR_SEQUENCE:
+		return fmt.Sprintf("%s %q", t.Type, t.Literal)
+	}
+
+	return describeType(t.Type)
 }
@@ -1 +1,338 @@
 package parser
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtarcea/som/internal/ast"
+	"github.com/gtarcea/som/internal/lexer"
+	"github.com/gtarcea/som/internal/token"
+)
+
+func TestParse(t *testing.T) {
+	input := `Counter = Object (
+  | count step |
+  "Answers the count after incrementing it"
+  increment = ( count := count + step. ^count )
+  at: i put: v = ( ^#(1 2 #three (4)) at: i put: v negated * -2 )
+  <= other = ( ^(self > other) not )
+  do: block = ( 1 to: count do: [:i | | x | x := i. block value: x ] )
+  ----
+  | instances |
+  new = ( instances := instances + 1. ^super new )
+  primitiveCount = primitive
+)`
+
+	class, err := New(lexer.NewLexer(input)).Parse()
+	require.NoError(t, err)
+	require.Equal(t, "Counter", class.Name.Name)
+	require.Equal(t, "Object", class.Superclass.Name)
+	require.Equal(t, []string{"count", "step"}, names(class.InstanceFields))
+	require.Equal(t, []string{"instances"}, names(class.ClassFields))
+
+	var selectors []string
+	for _, m := range append(class.InstanceMethods, class.ClassMethods...) {
+		selectors = append(selectors, m.Selector)
+	}
+	require.Equal(t, []string{"increment", "at:put:", "<=", "do:", "new", "primitiveCount"}, selectors)
+	require.True(t, class.ClassMethods[1].Primitive)
+	require.True(t, class.ClassMethods[1].ClassSide)
+
+	atPut := class.InstanceMethods[1]
+	require.Equal(t, []string{"i", "v"}, names(atPut.Arguments))
+	ret := atPut.Body[0].(*ast.Return)
+	send := ret.Value.(*ast.Send)
+	require.Equal(t, "at:put:", send.Selector)
+	require.IsType(t, &ast.ArrayLiteral{}, send.Receiver)
+	// v negated * -2 binds as (v negated) * -2
+	arg := send.Arguments[1].(*ast.Send)
+	require.Equal(t, "*", arg.Selector)
+	require.Equal(t, "negated", arg.Receiver.(*ast.Send).Selector)
+	require.Equal(t, "-2", arg.Arguments[0].(*ast.Literal).Value)
+
+	block := class.InstanceMethods[3].Body[0].(*ast.Send).Arguments[1].(*ast.Block)
+	require.Equal(t, []string{"i"}, names(block.Parameters))
+	require.Equal(t, []string{"x"}, names(block.Locals))
+	require.Len(t, block.Body, 2)
+}
+
+func TestParseStrings(t *testing.T) {
+	input := `Strings = ( s = ( ^'it\'s\ta \\ \q' ) )`
+
+	class, err := New(lexer.NewLexer(input)).Parse()
+	require.NoError(t, err)
+	lit := class.InstanceMethods[0].Body[0].(*ast.Return).Value.(*ast.Literal)
+	require.Equal(t, "it's\ta \\ q", lit.Value)
+	require.Equal(t, `'it\'s\ta \\ \q'`, lit.Raw)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		expectedErrors []string
+	}{
+		{
+			name: "one error per broken method",
+			input: `Foo = (
+  a = ( ^1 + )
+  b = ( ^2 )
+  c = ( x := . )
+  d = ( ^'unterminated )
+)`,
+			expectedErrors: []string{
+				"2:14: expected expression, found \")\"",
+				"4:14: expected expression, found \".\"",
+				"5:10: unterminated string",
+			},
+		},
+		{
+			name: "unbalanced parentheses stop at the next method",
+			input: `Foo = (
+  a = ( ^(1 + 2.
+  b = ( ^2 ]
+  c = ( ^3 )
+)`,
+			expectedErrors: []string{
+				"2:16: expected \")\", found \".\"",
+				"3:12: expected \")\" after return, found \"]\"",
+			},
+		},
+		{
+			name:  "class side",
+			input: "Foo = ( a = ( ^1 ) ---- | b | c: = ( ^b ) d = ( ^b ) )",
+			expectedErrors: []string{
+				"1:34: expected argument name, found \"=\"",
+			},
+		},
+		{
+			name:  "broken header",
+			input: "Foo Bar ( a = ( ^1 ) )",
+			expectedErrors: []string{
+				"1:5: expected \"=\", found identifier \"Bar\"",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := New(lexer.NewLexer(test.input)).Parse()
+			require.Error(t, err)
+
+			var messages []string
+			for _, e := range err.(*multierror.Error).Errors {
+				messages = append(messages, e.Error())
+			}
+			require.Equal(t, test.expectedErrors, messages)
+		})
+	}
+}
+
+func TestParseHints(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedHint string
+	}{
+		{"Foo = ( a = ( ^x ifTrue: [ 1 ] ifFalse [ 2 ] ) )", "did you mean `ifTrue:ifFalse:`?"},
+		{"Foo = ( a = ( x do [ 1 ] ) )", "did you mean `do:`?"},
+		{"Foo = ( a = ( x foo ] ) )", ""},
+		{"Foo = ( a = ( ^d at:key ) )", "add a space after `at:` to pass `key` as its argument"},
+		{"Foo = ( a = ( ^1. x := 2 ) )", "statements after a return are unreachable"},
+		{"Foo = ( a = ( ^[ ^1. 2 ] ) )", "statements after a return are unreachable"},
+		{"Foo = ( a = ( ^1. ] ) )", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			_, err := New(lexer.NewLexer(test.input)).Parse()
+			require.Error(t, err)
+			require.Equal(t, test.expectedHint, err.(*multierror.Error).Errors[0].(*Error).Hint)
+		})
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	seeds := []string{
+		"",
+		"Hello = (\n  run = ( 'Hello, World from SOM' println )\n)",
+		"Foo = Bar ( | a b | at: i put: v = ( ^a at: i put: v ) ---- new = ( ^super new ) )",
+		"Foo = ( bar = ( 'unterminated",
+		"Foo = ( a = ( ^(1 + 2.\n  b = ( ^2 ]\n  c = ( ^3 )\n)",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		// A parser that loops forever is reported as a hang by go test -fuzz.
+		checkParse(t, input)
+	})
+}
+
+// checkParse parses input, in tolerant mode too, and checks that every error
+// is an *Error with a span that lies within the input and positions whose line
+// and column agree with their offset.
+func checkParse(t *testing.T, input string) {
+	for _, mode := range []Mode{0, Tolerant} {
+		_, err := NewWithMode(lexer.NewLexer(input), mode).Parse()
+		if err != nil {
+			checkErrors(t, input, err)
+		}
+	}
+}
+
+func checkErrors(t *testing.T, input string, err error) {
+	starts := lineStarts(input)
+	for _, e := range err.(*multierror.Error).Errors {
+		perr, ok := e.(*Error)
+		if !ok {
+			t.Errorf("unexpected error type %T: %s", e, e)
+			continue
+		}
+
+		if perr.Pos.Offset < 0 || perr.End.Offset < perr.Pos.Offset || perr.End.Offset > len(input) {
+			t.Errorf("error %q has bad span %d-%d for input length %d", perr, perr.Pos.Offset, perr.End.Offset, len(input))
+			continue
+		}
+		checkPosition(t, starts, perr.Pos)
+		checkPosition(t, starts, perr.End)
+		if perr.Msg == "" {
+			t.Errorf("error at %d:%d has no message", perr.Pos.Line, perr.Pos.Column)
+		}
+	}
+}
+
+func checkPosition(t *testing.T, lineStarts []int, pos token.Position) {
+	line := sort.SearchInts(lineStarts, pos.Offset+1)
+	column := pos.Offset - lineStarts[line-1] + 1
+
+	if pos.Line != line || pos.Column != column {
+		t.Errorf("offset %d reported as %d:%d, expected %d:%d", pos.Offset, pos.Line, pos.Column, line, column)
+	}
+}
+
+// lineStarts returns the offset of the start of each line in input.
+func lineStarts(input string) []int {
+	starts := []int{0}
+	for i := 0; i < len(input); i++ {
+		if input[i] == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+
+	return starts
+}
+
+func TestParseTolerant(t *testing.T) {
+	input := `Foo = (
+  a = ( x := . ^(1 + 2 foo: ]. ^3 )
+  b = ( ^[:x | x +  )
+  c = ( ^4 )
+)`
+
+	class, err := NewWithMode(lexer.NewLexer(input), Tolerant).Parse()
+	var messages []string
+	for _, e := range err.(*multierror.Error).Errors {
+		messages = append(messages, e.Error())
+	}
+	require.Equal(t, []string{
+		"2:14: expected expression, found \".\"",
+		"2:29: expected expression, found \"]\"",
+		"3:21: expected expression, found \")\"",
+	}, messages)
+	require.Len(t, class.InstanceMethods, 3)
+
+	// The statements around the broken ones are kept
+	a := class.InstanceMethods[0]
+	require.Len(t, a.Body, 3)
+	require.Equal(t, ast.Span{Start: token.Position{Offset: 16, Line: 2, Column: 9}, Stop: token.Position{Offset: 20, Line: 2, Column: 13}},
+		a.Body[0].(*ast.BadExpr).Span)
+	require.IsType(t, &ast.BadExpr{}, a.Body[1])
+	require.Equal(t, "3", a.Body[2].(*ast.Return).Value.(*ast.Literal).Value)
+
+	// The unclosed block ends with the method
+	b := class.InstanceMethods[1]
+	block := b.Body[0].(*ast.Return).Value.(*ast.Block)
+	require.Equal(t, []string{"x"}, names(block.Parameters))
+	require.IsType(t, &ast.BadExpr{}, block.Body[0])
+
+	require.Equal(t, "c", class.InstanceMethods[2].Selector)
+}
+
+func TestParseTolerantOpenAtLineEnd(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedError string
+	}{
+		{"keyword argument", "Foo = (\n  b = ( self foo: \n  c = ( ^2 )\n)", "3:3: expected expression, found identifier \"c\""},
+		{"binary argument", "Foo = (\n  b = ( ^1 + \n  c = ( ^2 )\n)", "3:3: expected expression, found identifier \"c\""},
+		{"array element", "Foo = (\n  b = ( ^#( 1 \n  c = ( ^2 )\n)", "3:3: expected \")\", found identifier \"c\""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			class, err := NewWithMode(lexer.NewLexer(test.input), Tolerant).Parse()
+			require.EqualError(t, err.(*multierror.Error).Errors[0], test.expectedError)
+
+			// The next method isn't swallowed by the broken one
+			require.Len(t, class.InstanceMethods, 2)
+			require.Equal(t, "c", class.InstanceMethods[1].Selector)
+			require.IsType(t, &ast.BadExpr{}, class.InstanceMethods[0].Body[0])
+		})
+	}
+}
+
+func TestReparse(t *testing.T) {
+	input := `Foo = (
+  a = ( ^1 ) b = ( "b" ^2 )
+  "c"
+  c = ( ^3 )
+  ----
+  d = ( ^4 ) "d"
+)`
+
+	tests := []struct {
+		name string
+		edit Edit
+		// reused is whether c, which none of the edits touch, is kept
+		reused bool
+	}{
+		{"within a method", Edit{Start: 32, End: 33, Text: "2 +\n    \"more\" 5"}, true},
+		{"same line after", Edit{Start: 17, End: 18, Text: "10"}, true},
+		{"class side", Edit{Start: 71, End: 72, Text: "4 - 1"}, true},
+		{"deleting", Edit{Start: 27, End: 31, Text: ""}, true},
+		{"unbalanced", Edit{Start: 17, End: 18, Text: "(1"}, false},
+		{"between methods", Edit{Start: 20, End: 20, Text: "\"x\" "}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			class, err := New(lexer.NewLexer(input)).Parse()
+			require.NoError(t, err)
+			c := class.InstanceMethods[2]
+
+			src := input[:test.edit.Start] + test.edit.Text + input[test.edit.End:]
+			reparsed, reparseErr := Reparse(class, src, test.edit, 0)
+			expected, err := New(lexer.NewLexer(src)).Parse()
+			require.Equal(t, err, reparseErr)
+			require.Equal(t, expected, reparsed)
+
+			if test.reused {
+				require.True(t, c == reparsed.InstanceMethods[2])
+			}
+		})
+	}
+}
+
+func names(idents []*ast.Ident) []string {
+	var n []string
+	for _, ident := range idents {
+		n = append(n, ident.Name)
+	}
+
+	return n
+}
@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"github.com/gtarcea/som/internal/ast"
+	"github.com/gtarcea/som/internal/lexer"
+	"github.com/gtarcea/som/internal/token"
+)
+
+// Edit is a change to source text, replacing the bytes from Start to End with
+// Text.
+type Edit struct {
+	Start int
+	End   int
+	Text  string
+}
+
+// Reparse returns the class for src, which is the source class was parsed
+// from with edit applied. When the edit falls within a single method only that
+// method is parsed again, the rest of class is reused with its positions moved
+// to match src, so class must not be used afterwards. Otherwise, or when the
+// edited method no longer parses cleanly on its own, src is parsed in full.
+//
+// class must have been parsed without syntax errors, those in other methods
+// would be lost otherwise.
+func Reparse(class *ast.Class, src string, edit Edit, mode Mode) (*ast.Class, error) {
+	methods, i := findMethod(class, edit)
+	if methods == nil {
+		return NewWithMode(lexer.NewLexer(src), mode).Parse()
+	}
+	old := methods[i]
+
+	delta := len(edit.Text) - (edit.End - edit.Start)
+	p := NewWithMode(lexer.NewLexerAt(src, old.Pos()), mode)
+	method, ok := p.parseMethod()
+	if !ok || p.errors.ErrorOrNil() != nil || p.prevToken.End.Offset != old.End().Offset+delta {
+		return NewWithMode(lexer.NewLexer(src), mode).Parse()
+	}
+	method.ClassSide = old.ClassSide
+
+	// Move everything after the method, it all shifts by the same amount. The
+	// old method's comments are dropped first, and its nodes are left alone as
+	// they come before oldEnd.
+	var before, after []token.Token
+	for _, c := range class.Comments {
+		switch {
+		case c.Pos.Offset < old.Pos().Offset:
+			before = append(before, c)
+		case c.Pos.Offset >= old.End().Offset:
+			after = append(after, c)
+		}
+	}
+	class.Comments = after
+
+	oldEnd, newEnd := old.End(), method.End()
+	ast.Reposition(class, func(pos token.Position) token.Position {
+		if pos.Offset < oldEnd.Offset {
+			return pos
+		}
+		if pos.Line == oldEnd.Line {
+			pos.Column += newEnd.Column - oldEnd.Column
+		}
+		pos.Line += newEnd.Line - oldEnd.Line
+		pos.Offset += delta
+		return pos
+	})
+
+	// The parser may have read comments past the end of the method
+	comments := before
+	for _, c := range p.comments {
+		if c.End.Offset <= newEnd.Offset {
+			comments = append(comments, c)
+		}
+	}
+	class.Comments = append(comments, class.Comments...)
+	methods[i] = method
+
+	return class, nil
+}
+
+// findMethod returns the methods of class holding the method that edit falls
+// within along with its index, or nil if there is none.
+func findMethod(class *ast.Class, edit Edit) ([]*ast.Method, int) {
+	for _, methods := range [][]*ast.Method{class.InstanceMethods, class.ClassMethods} {
+		for i, m := range methods {
+			if m.Pos().Offset <= edit.Start && edit.End <= m.End().Offset {
+				return methods, i
+			}
+		}
+	}
+
+	return nil, 0
+}
@@ -0,0 +1,577 @@
+package printer
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/gtarcea/som/internal/ast"
+	"github.com/gtarcea/som/internal/lexer"
+	"github.com/gtarcea/som/internal/parser"
+	"github.com/gtarcea/som/internal/token"
+)
+
+const (
+	indentWidth = 4
+	maxWidth    = 80
+)
+
+// Operator precedence, an operand that binds looser than its context allows
+// needs parentheses.
+const (
+	primaryPrecedence = iota
+	unaryPrecedence
+	binaryPrecedence
+	keywordPrecedence
+	assignmentPrecedence
+)
+
+type printer struct {
+	comments []token.Token
+	// next is the index of the first comment not yet printed.
+	next int
+}
+
+// Format parses src and returns it in the canonical layout. If src has syntax
+// errors it is left alone and the errors are returned as from parser.Parse.
+func Format(src string) (string, error) {
+	class, err := parser.New(lexer.NewLexer(src)).Parse()
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if err := Fprint(&b, class); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// Fprint writes class to w in the canonical layout: four space indents, a blank
+// line between methods, methods that fit kept on a single line, and keyword
+// messages too long for one line split with one aligned keyword per line. The
+// comments in class.Comments are kept where they appear: between statements
+// and methods on lines of their own or at the end of a line, and inside method
+// patterns, declarations and expressions between the same tokens as in the
+// source.
+//
+// class must come from a parse without errors, it is an error for it to have
+// no name and the layout of anything else the parser left out is undefined.
+func Fprint(w io.Writer, class *ast.Class) error {
+	if class.Name == nil {
+		return errors.New("class has no name")
+	}
+
+	p := &printer{comments: class.Comments}
+	_, err := io.WriteString(w, strings.Join(p.class(class), "\n")+"\n")
+	return err
+}
+
+func (p *printer) class(c *ast.Class) []string {
+	lines, last := p.flushComments(nil, c.Pos().Offset, 0, 0)
+	if last != 0 && c.Pos().Line > last+1 {
+		lines = append(lines, "")
+	}
+
+	header := c.Name.Name + " = ("
+	if c.Superclass != nil {
+		header = c.Name.Name + " = " + c.Superclass.Name + " ("
+	}
+	lines = append(lines, header)
+	last = c.Pos().Line
+
+	// Each element of the class body is a field declaration, a method or the
+	// class side separator, and elements are separated by a blank line.
+	var elements []ast.Node
+	var declarations []ast.Node
+	if len(c.InstanceFields) > 0 {
+		elements = append(elements, fieldList{c.InstanceFields, c.InstanceFieldsBar})
+	}
+	for _, m := range c.InstanceMethods {
+		elements = append(elements, m)
+	}
+	if len(c.ClassFields) > 0 || len(c.ClassMethods) > 0 {
+		declarations = append(declarations, separator{})
+		if len(c.ClassFields) > 0 {
+			declarations = append(declarations, fieldList{c.ClassFields, c.ClassFieldsBar})
+		}
+		for _, m := range c.ClassMethods {
+			declarations = append(declarations, m)
+		}
+	}
+
+	for i, element := range append(elements, declarations...) {
+		if i > 0 {
+			next := element.Pos().Offset
+			if _, ok := element.(separator); ok {
+				// The separator has no position
+				next = c.End().Offset
+			}
+			lines = p.trailingComments(lines, next, last)
+			lines = append(lines, "")
+			last = 0
+		}
+		if _, ok := element.(separator); !ok {
+			lines, _ = p.flushComments(lines, element.Pos().Offset, last, 1)
+		}
+
+		switch e := element.(type) {
+		case separator:
+			lines = append(lines, indent(1)+"----")
+			continue
+		case fieldList:
+			lines = append(lines, indent(1)+p.variables(e.vars, e.bar))
+			p.skip(e.End().Offset)
+		case *ast.Method:
+			lines = append(lines, p.method(e)...)
+		}
+		last = element.End().Line
+	}
+
+	lines, _ = p.flushComments(lines, c.End().Offset, last, 1)
+	lines = append(lines, ")")
+	lines, _ = p.flushComments(lines, int(^uint(0)>>1), c.End().Line, 0)
+
+	return lines
+}
+
+// fieldList and separator let field declarations and the class side separator
+// be laid out alongside methods.
+type fieldList struct {
+	vars []*ast.Ident
+	bar  token.Position
+}
+
+func (f fieldList) Pos() token.Position { return f.vars[0].Pos() }
+func (f fieldList) End() token.Position {
+	return token.Position{Offset: closing(f.vars[len(f.vars)-1].End().Offset, f.bar), Line: f.vars[len(f.vars)-1].End().Line}
+}
+
+type separator struct{}
+
+func (separator) Pos() token.Position { return token.Position{} }
+func (separator) End() token.Position { return token.Position{} }
+
+func (p *printer) method(m *ast.Method) []string {
+	// The comments in the pattern, and between it and the body, stay in the
+	// pattern
+	var words []string
+	prev := m.Pos().Offset
+	for i, part := range m.Parts {
+		words = append(words, p.commentsIn(prev, part.Pos.Offset)...)
+		words = append(words, part.Literal)
+		prev = part.End.Offset
+		if i < len(m.Arguments) {
+			words = append(words, p.commentsIn(prev, m.Arguments[i].Pos().Offset)...)
+			words = append(words, m.Arguments[i].Name)
+			prev = m.Arguments[i].End().Offset
+		}
+	}
+	words = append(words, p.commentsIn(prev, m.Open.Offset)...)
+	pattern := strings.Join(words, " ")
+	p.skip(m.Open.Offset)
+
+	if m.Primitive {
+		return []string{indent(1) + pattern + " = primitive"}
+	}
+
+	switch {
+	case len(m.Locals) > 0 || len(m.Body) > 1:
+	case len(m.Body) == 0:
+		if !p.hasComments(m.Open.Offset, m.End().Offset) {
+			return []string{indent(1) + pattern + " = ( )"}
+		}
+	case !p.hasComments(m.Open.Offset, m.Body[0].Pos().Offset) && !p.hasComments(tail(m.Body[0]), m.End().Offset):
+		line := indent(1) + pattern + " = ( " + p.flat(m.Body[0]) + " )"
+		if fits(line, 0) {
+			p.skip(tail(m.Body[0]))
+			return []string{line}
+		}
+	}
+
+	lines := []string{indent(1) + pattern + " = ("}
+	lines = append(lines, p.body(m.Locals, m.LocalsBar, m.Body, m.End().Offset, 2)...)
+	return append(lines, indent(1)+")")
+}
+
+// body lays out locals and statements one per line at the given indent level,
+// along with the comments before end.
+func (p *printer) body(locals []*ast.Ident, bar token.Position, body []ast.Expression, end int, level int) []string {
+	var lines []string
+	last := 0
+
+	if len(locals) > 0 {
+		lines, last = p.flushComments(lines, locals[0].Pos().Offset, last, level)
+		lines = append(lines, indent(level)+p.variables(locals, bar))
+		p.skip(closing(locals[len(locals)-1].End().Offset, bar))
+		last = locals[len(locals)-1].End().Line
+	}
+
+	for i, stmt := range body {
+		lines, last = p.flushComments(lines, stmt.Pos().Offset, last, level)
+		if last != 0 && stmt.Pos().Line > last+1 {
+			// Keep a blank line between groups of statements
+			lines = append(lines, "")
+		}
+
+		s := p.expr(stmt, level, level*indentWidth)
+		if i < len(body)-1 {
+			s += "."
+		}
+		lines = append(lines, indent(level)+s)
+		p.skip(tail(stmt))
+		last = stmt.End().Line
+	}
+
+	lines, _ = p.flushComments(lines, end, last, level)
+	return lines
+}
+
+// flushComments adds the comments before offset to lines. Comments that start
+// on or before line last, the source line of the last thing printed, are
+// added to the end of the last line. It returns the source line of the last
+// comment added.
+func (p *printer) flushComments(lines []string, offset, last, level int) ([]string, int) {
+	for ; p.next < len(p.comments) && p.comments[p.next].Pos.Offset < offset; p.next++ {
+		c := p.comments[p.next]
+
+		switch {
+		case len(lines) > 0 && last != 0 && c.Pos.Line <= last:
+			lines[len(lines)-1] += " " + c.Literal
+		case last != 0 && c.Pos.Line > last+1:
+			lines = append(lines, "", indent(level)+c.Literal)
+		default:
+			lines = append(lines, indent(level)+c.Literal)
+		}
+		last = c.End.Line
+	}
+
+	return lines, last
+}
+
+// trailingComments adds the comments before offset that start on or before
+// line last to the end of the last line.
+func (p *printer) trailingComments(lines []string, offset, last int) []string {
+	for ; p.next < len(p.comments) && p.comments[p.next].Pos.Line <= last && p.comments[p.next].Pos.Offset < offset; p.next++ {
+		lines[len(lines)-1] += " " + p.comments[p.next].Literal
+	}
+
+	return lines
+}
+
+// hasComments reports whether there are comments still to print between
+// start and end.
+func (p *printer) hasComments(start, end int) bool {
+	return len(p.commentsIn(start, end)) > 0
+}
+
+// commentsIn returns the comments still to print between the offsets start
+// and end. They aren't marked as printed, since expressions are laid out more
+// than once to find one that fits.
+func (p *printer) commentsIn(start, end int) []string {
+	var comments []string
+	for _, c := range p.comments[p.next:] {
+		if c.Pos.Offset >= end {
+			break
+		}
+		if c.Pos.Offset >= start {
+			comments = append(comments, c.Literal)
+		}
+	}
+
+	return comments
+}
+
+// gap returns the comments between start and end each preceded by a space,
+// to follow whatever was printed before them.
+func (p *printer) gap(start, end int) string {
+	var b strings.Builder
+	for _, c := range p.commentsIn(start, end) {
+		b.WriteString(" " + c)
+	}
+
+	return b.String()
+}
+
+// skip marks the comments before offset as printed.
+func (p *printer) skip(offset int) {
+	for p.next < len(p.comments) && p.comments[p.next].Pos.Offset < offset {
+		p.next++
+	}
+}
+
+// tail returns the offset just after the start of the last token of e. An
+// expression prints the comments between its tokens, the comments after tail
+// go after it even if the source had them before a closing parenthesis, which
+// keeps them after the same token however e is parenthesized.
+func tail(e ast.Expression) int {
+	switch e := e.(type) {
+	case *ast.Assignment:
+		return tail(e.Value)
+	case *ast.Return:
+		return tail(e.Value)
+	case *ast.Send:
+		if n := len(e.Arguments); n > 0 {
+			return tail(e.Arguments[n-1])
+		}
+		return e.Parts[0].Pos.Offset + 1
+	case *ast.Block, *ast.ArrayLiteral:
+		// Ends with a one character ] or )
+		return e.End().Offset
+	}
+
+	// A literal is a single token for comments, even -1 or #foo
+	return e.Pos().Offset + 1
+}
+
+// expr lays out e starting at column col on a line indented level times. It
+// uses a single line if e fits, otherwise it splits blocks and keyword
+// messages over several lines. The comments inside e are printed after the
+// same token as in the source.
+func (p *printer) expr(e ast.Expression, level, col int) string {
+	if s := p.flat(e); fits(s, col) {
+		return s
+	}
+
+	switch e := e.(type) {
+	case *ast.Assignment:
+		prefix := e.Variable.Name + " :=" + p.gap(e.Variable.End().Offset, e.Value.Pos().Offset) + " "
+		return prefix + p.expr(e.Value, level, column(prefix, col))
+	case *ast.Return:
+		prefix := p.caret(e)
+		return prefix + p.expr(e.Value, level, column(prefix, col))
+	case *ast.Block:
+		return p.block(e, level)
+	case *ast.Send:
+		return p.send(e, level, col)
+	}
+
+	return p.flat(e)
+}
+
+// caret returns the ^ of r with the comments between it and the value.
+func (p *printer) caret(r *ast.Return) string {
+	if comments := p.gap(r.Pos().Offset+1, r.Value.Pos().Offset); comments != "" {
+		return "^" + comments + " "
+	}
+
+	return "^"
+}
+
+func (p *printer) send(e *ast.Send, level, col int) string {
+	precedence := sendPrecedence(e)
+	receiver := p.operand(e.Receiver, receiverPrecedence(precedence), level, col)
+	receiver += p.gap(tail(e.Receiver), e.Parts[0].Pos.Offset)
+
+	switch {
+	case precedence == unaryPrecedence:
+		return receiver + " " + e.Selector
+	case precedence == binaryPrecedence, len(e.Parts) == 1:
+		argPrecedence := binaryPrecedence
+		if precedence == binaryPrecedence {
+			argPrecedence = unaryPrecedence
+		}
+		prefix := receiver + " " + e.Parts[0].Literal + p.gap(e.Parts[0].End.Offset, e.Arguments[0].Pos().Offset) + " "
+		return prefix + p.operand(e.Arguments[0], argPrecedence, level, column(prefix, col))
+	}
+
+	// Put each keyword on its own line, lined up one level in from the statement
+	var b strings.Builder
+	b.WriteString(receiver)
+	for i, part := range e.Parts {
+		if i > 0 {
+			b.WriteString(p.gap(tail(e.Arguments[i-1]), part.Pos.Offset))
+		}
+		prefix := indent(level+1) + part.Literal + p.gap(part.End.Offset, e.Arguments[i].Pos().Offset) + " "
+		b.WriteString("\n" + prefix)
+		b.WriteString(p.operand(e.Arguments[i], binaryPrecedence, level+1, len(prefix)))
+	}
+
+	return b.String()
+}
+
+// operand lays out the receiver or argument of a message, in parentheses if it
+// binds looser than precedence.
+func (p *printer) operand(e ast.Expression, precedence, level, col int) string {
+	if precedenceOf(e) > precedence {
+		return "(" + p.expr(e, level, col+1) + ")"
+	}
+
+	return p.expr(e, level, col)
+}
+
+func (p *printer) block(b *ast.Block, level int) string {
+	p.skip(b.Pos().Offset + 1)
+
+	header := "["
+	prev := b.Pos().Offset + 1
+	for _, param := range b.Parameters {
+		header += p.gap(prev, param.Pos().Offset) + " :" + param.Name
+		prev = param.End().Offset
+	}
+	if len(b.Parameters) > 0 {
+		header += p.gap(prev, b.ParametersBar.Offset) + " |"
+		prev = closing(prev, b.ParametersBar)
+	}
+	p.skip(prev)
+
+	lines := p.body(b.Locals, b.LocalsBar, b.Body, b.End().Offset-1, level+1)
+	if len(lines) == 0 {
+		return header + " ]"
+	}
+
+	return header + "\n" + strings.Join(lines, "\n") + " ]"
+}
+
+// flat lays out e on a single line, although string literals and comments may
+// themselves span lines.
+func (p *printer) flat(e ast.Expression) string {
+	switch e := e.(type) {
+	case *ast.Variable:
+		return e.Name
+	case *ast.Literal:
+		return e.Raw
+	case *ast.ArrayLiteral:
+		var words []string
+		prev := e.Pos().Offset + 1
+		for _, element := range e.Elements {
+			words = append(words, p.commentsIn(prev, element.Pos().Offset)...)
+			words = append(words, p.flat(element))
+			prev = tail(element)
+		}
+		words = append(words, p.commentsIn(prev, e.End().Offset-1)...)
+		return "#(" + strings.Join(words, " ") + ")"
+	case *ast.Assignment:
+		return e.Variable.Name + " :=" + p.gap(e.Variable.End().Offset, e.Value.Pos().Offset) + " " + p.flat(e.Value)
+	case *ast.Return:
+		return p.caret(e) + p.flat(e.Value)
+	case *ast.Block:
+		var b strings.Builder
+		b.WriteString("[")
+		prev := e.Pos().Offset + 1
+		for _, param := range e.Parameters {
+			b.WriteString(p.gap(prev, param.Pos().Offset) + " :" + param.Name)
+			prev = param.End().Offset
+		}
+		if len(e.Parameters) > 0 {
+			b.WriteString(p.gap(prev, e.ParametersBar.Offset) + " |")
+			prev = closing(prev, e.ParametersBar)
+		}
+		if len(e.Locals) > 0 {
+			b.WriteString(p.gap(prev, e.Locals[0].Pos().Offset) + " " + p.variables(e.Locals, e.LocalsBar))
+			prev = closing(e.Locals[len(e.Locals)-1].End().Offset, e.LocalsBar)
+		}
+		for i, stmt := range e.Body {
+			b.WriteString(p.gap(prev, stmt.Pos().Offset) + " " + p.flat(stmt))
+			if i < len(e.Body)-1 {
+				b.WriteString(".")
+			}
+			prev = tail(stmt)
+		}
+		b.WriteString(p.gap(prev, e.End().Offset-1) + " ]")
+		return b.String()
+	case *ast.Send:
+		precedence := sendPrecedence(e)
+		argPrecedence := binaryPrecedence
+		if precedence == binaryPrecedence {
+			argPrecedence = unaryPrecedence
+		}
+
+		s := p.flatOperand(e.Receiver, receiverPrecedence(precedence))
+		prev := tail(e.Receiver)
+		for i, part := range e.Parts {
+			s += p.gap(prev, part.Pos.Offset) + " " + part.Literal
+			if i < len(e.Arguments) {
+				arg := e.Arguments[i]
+				s += p.gap(part.End.Offset, arg.Pos().Offset) + " " + p.flatOperand(arg, argPrecedence)
+				prev = tail(arg)
+			}
+		}
+		return s
+	}
+
+	return ""
+}
+
+func (p *printer) flatOperand(e ast.Expression, precedence int) string {
+	if precedenceOf(e) > precedence {
+		return "(" + p.flat(e) + ")"
+	}
+
+	return p.flat(e)
+}
+
+func precedenceOf(e ast.Expression) int {
+	switch e := e.(type) {
+	case *ast.Send:
+		return sendPrecedence(e)
+	case *ast.Assignment, *ast.Return:
+		return assignmentPrecedence
+	}
+
+	return primaryPrecedence
+}
+
+func sendPrecedence(e *ast.Send) int {
+	switch {
+	case len(e.Arguments) == 0:
+		return unaryPrecedence
+	case strings.HasSuffix(e.Selector, ":"):
+		return keywordPrecedence
+	}
+
+	return binaryPrecedence
+}
+
+// receiverPrecedence returns the loosest binding receiver a message of the
+// given precedence can have without parentheses. Keyword messages aren't
+// chained, a keyword message sent to the result of another needs them.
+func receiverPrecedence(precedence int) int {
+	if precedence == keywordPrecedence {
+		return binaryPrecedence
+	}
+
+	return precedence
+}
+
+// variables lays out a declaration of vars closed by bar, with the comments
+// between them and before bar.
+func (p *printer) variables(vars []*ast.Ident, bar token.Position) string {
+	words := []string{vars[0].Name}
+	for i, v := range vars[1:] {
+		words = append(words, p.commentsIn(vars[i].End().Offset, v.Pos().Offset)...)
+		words = append(words, v.Name)
+	}
+	words = append(words, p.commentsIn(vars[len(vars)-1].End().Offset, bar.Offset)...)
+
+	return "| " + strings.Join(words, " ") + " |"
+}
+
+// closing returns the offset just after bar, the | closing a declaration that
+// otherwise ends at end, or end if bar is missing.
+func closing(end int, bar token.Position) int {
+	if bar.Offset < end {
+		return end
+	}
+
+	return bar.Offset + 1
+}
+
+func indent(level int) string {
+	return strings.Repeat(" ", level*indentWidth)
+}
+
+// fits reports whether s fits on a single line starting at column col.
+func fits(s string, col int) bool {
+	return !strings.Contains(s, "\n") && col+len(s) <= maxWidth
+}
+
+// column returns the column after s when s is written starting at col.
+func column(s string, col int) int {
+	if i := strings.LastIndexByte(s, '\n'); i != -1 {
+		return len(s) - i - 1
+	}
+
+	return col + len(s)
+}
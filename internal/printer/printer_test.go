@@ -0,0 +1,235 @@
+package printer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtarcea/som/internal/lexer"
+	"github.com/gtarcea/som/internal/parser"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:  "layout",
+			input: "Counter   =   Object(\n  |count step|\n  value = (^count)\n  increment   = ( count := count + step. ^self )\n  print = primitive\n---------\n |instances|\n  new = ( ^super new initialize )\n)",
+			expected: `Counter = Object (
+    | count step |
+
+    value = ( ^count )
+
+    increment = (
+        count := count + step.
+        ^self
+    )
+
+    print = primitive
+
+    ----
+
+    | instances |
+
+    new = ( ^super new initialize )
+)
+`,
+		},
+		{
+			name:  "parentheses",
+			input: "Foo = ( a = ( ^((a foo: 1) bar: ((2 + 3) abs)) , (x := 4 - -5) ) b = ( ^(((self size) + 1) max: (2 * 3)) ) )",
+			expected: `Foo = (
+    a = ( ^((a foo: 1) bar: (2 + 3) abs) , (x := 4 - -5) )
+
+    b = ( ^self size + 1 max: 2 * 3 )
+)
+`,
+		},
+		{
+			name:  "long keyword messages",
+			input: "Foo = ( d: x = ( x > 0 ifTrue: [ self someVeryLongSelectorName: x with: x + 1 and: x + 2 andAlso: #(1 #foo 'x') ] ifFalse: [ ^self d: x - 1 ] ) )",
+			expected: `Foo = (
+    d: x = (
+        x > 0
+            ifTrue: [
+                self
+                    someVeryLongSelectorName: x
+                    with: x + 1
+                    and: x + 2
+                    andAlso: #(1 #foo 'x') ]
+            ifFalse: [ ^self d: x - 1 ]
+    )
+)
+`,
+		},
+		{
+			name: "comments",
+			input: `"Header"
+Foo = ( "fields"
+  | a |
+  "Answers one"
+  one = ( ^1 "one" )
+  two = ( | x | x := 2. "set x"
+
+     "blank line above" ^x )   "trailing"
+  "before end"
+)
+"trailer"`,
+			expected: `"Header"
+Foo = ( "fields"
+    | a |
+
+    "Answers one"
+    one = (
+        ^1 "one"
+    )
+
+    two = (
+        | x |
+        x := 2. "set x"
+
+        "blank line above"
+        ^x
+    ) "trailing"
+    "before end"
+)
+"trailer"
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := Format(test.input)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, actual)
+			requireIdempotent(t, test.input, actual)
+		})
+	}
+}
+
+func TestFormatInteriorComments(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "keyword message",
+			input:    `Foo = ( c = ( self foo: 1 "after arg" bar: 2. ^3 ) )`,
+			expected: "Foo = (\n    c = (\n        self foo: 1 \"after arg\" bar: 2.\n        ^3\n    )\n)\n",
+		},
+		{
+			name:     "method pattern",
+			input:    `Foo = ( e "c" = ( ^1 ) at: i "index" put: v = ( ^v ) )`,
+			expected: "Foo = (\n    e \"c\" = ( ^1 )\n\n    at: i \"index\" put: v = ( ^v )\n)\n",
+		},
+		{
+			name:     "array",
+			input:    `Foo = ( a = ( ^#(1 "one" 2 "two") ) )`,
+			expected: "Foo = (\n    a = ( ^#(1 \"one\" 2 \"two\") )\n)\n",
+		},
+		{
+			name:     "mid-expression",
+			input:    `Foo = ( a = ( | x "the x" y | x := "set" 1 + "plus" 2. ^ "answer" [:e "elem" | e ] value: x ) )`,
+			expected: "Foo = (\n    a = (\n        | x \"the x\" y |\n        x := \"set\" 1 + \"plus\" 2.\n        ^ \"answer\" [ :e \"elem\" | e ] value: x\n    )\n)\n",
+		},
+		{
+			name:     "last local",
+			input:    `Foo = ( | f "field" | a = ( | l "local" | l := [:p | | b "block" | b ]. ^l ) )`,
+			expected: "Foo = (\n    | f \"field\" |\n\n    a = (\n        | l \"local\" |\n        l := [ :p | | b \"block\" | b ].\n        ^l\n    )\n)\n",
+		},
+		{
+			name:     "last block parameter",
+			input:    `Foo = ( a = ( ^[:p "param" | p ] ) b = ( #(1) do: [:e "elem" | | x | x := e. x ]. ^[:p "param" || q | q ] ) )`,
+			expected: "Foo = (\n    a = ( ^[ :p \"param\" | p ] )\n\n    b = (\n        #(1) do: [ :e \"elem\" | | x | x := e. x ].\n        ^[ :p \"param\" | | q | q ]\n    )\n)\n",
+		},
+		{
+			name: "split keyword message",
+			input: `Foo = ( d: x = ( x > 0 "positive" ifTrue: [ self someVeryLongSelectorName: x "first" with: x + 1 and: x + 2 andAlso: x + 3 ]
+  ifFalse: "otherwise" [ ^self d: x - 1 ] ) )`,
+			expected: `Foo = (
+    d: x = (
+        x > 0 "positive"
+            ifTrue: [
+                self
+                    someVeryLongSelectorName: x "first"
+                    with: x + 1
+                    and: x + 2
+                    andAlso: x + 3 ]
+            ifFalse: "otherwise" [ ^self d: x - 1 ]
+    )
+)
+`,
+		},
+		{
+			name:     "class side",
+			input:    "Foo = ( a = ( ^1 ) \"a\"\n  ---- \"class side\"\n  b \"b\" = ( ^2 ) )",
+			expected: "Foo = (\n    a = ( ^1 ) \"a\"\n\n    ----\n\n    \"class side\"\n    b \"b\" = ( ^2 )\n)\n",
+		},
+		{
+			name: "multi-line comment in a block",
+			input: `Foo = ( a = ( ^[ "first
+second" 1 ] ) )`,
+			expected: `Foo = (
+    a = (
+        ^[
+            "first
+second"
+            1 ]
+    )
+)
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := Format(test.input)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, actual)
+			requireIdempotent(t, test.input, actual)
+		})
+	}
+}
+
+// requireIdempotent checks that formatting formatted source leaves it alone
+// and that no comment of the input was lost along the way.
+func requireIdempotent(t *testing.T, input, formatted string) {
+	t.Helper()
+
+	again, err := Format(formatted)
+	require.NoError(t, err)
+	require.Equal(t, formatted, again)
+
+	original, err := parser.New(lexer.NewLexer(input)).Parse()
+	require.NoError(t, err)
+	class, err := parser.New(lexer.NewLexer(formatted)).Parse()
+	require.NoError(t, err)
+	var expected, actual []string
+	for _, c := range original.Comments {
+		expected = append(expected, c.Literal)
+	}
+	for _, c := range class.Comments {
+		actual = append(actual, c.Literal)
+	}
+	require.Equal(t, expected, actual)
+}
+
+func TestFormatSyntaxError(t *testing.T) {
+	_, err := Format("Foo = ( a = ( ^1 + ) )")
+	require.EqualError(t, err, "1 error occurred:\n\t* 1:20: expected expression, found \")\"\n\n")
+}
+
+func TestFprintWithoutName(t *testing.T) {
+	class, err := parser.NewWithMode(lexer.NewLexer("= ( )"), parser.Tolerant).Parse()
+	require.Error(t, err)
+
+	var b strings.Builder
+	require.EqualError(t, Fprint(&b, class), "class has no name")
+	require.Empty(t, b.String())
+}
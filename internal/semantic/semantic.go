@@ -0,0 +1,345 @@
+package semantic
+
+import (
+	"fmt"
+	"unicode"
+
+	"github.com/gtarcea/som/internal/ast"
+	"github.com/gtarcea/som/internal/diagnostic"
+)
+
+// Classes finds the definitions of other classes, so that fields inherited
+// from superclasses can be resolved. LookupClass returns nil for classes it
+// doesn't know.
+type Classes interface {
+	LookupClass(name string) *ast.Class
+}
+
+// pseudoVariables can't be declared or assigned to.
+var pseudoVariables = map[string]ast.VariableKind{
+	"self":        ast.SelfVariable,
+	"super":       ast.SuperVariable,
+	"thisContext": ast.ThisContextVariable,
+	"nil":         ast.GlobalVariable,
+	"true":        ast.GlobalVariable,
+	"false":       ast.GlobalVariable,
+}
+
+// lowercaseGlobals are globals that don't start with an uppercase letter.
+var lowercaseGlobals = map[string]bool{
+	"system": true,
+}
+
+type declaration struct {
+	kind  ast.VariableKind
+	index int
+	ident *ast.Ident
+}
+
+// scope holds the arguments and locals of a method or block.
+type scope struct {
+	parent *scope
+	names  map[string]declaration
+	count  map[ast.VariableKind]int
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{
+		parent: parent,
+		names:  make(map[string]declaration),
+		count:  make(map[ast.VariableKind]int),
+	}
+}
+
+type analyzer struct {
+	class      *ast.Class
+	superclass string
+	// chainKnown is false when a superclass couldn't be found, in which case
+	// an unknown name may be an inherited field.
+	chainKnown  bool
+	fields      map[string]int
+	classFields map[string]int
+	classSide   bool
+	diags       []diagnostic.Diagnostic
+}
+
+// Analyze resolves every variable in class, filling in the Kind, Index and
+// Level of each ast.Variable, and reports undefined variables, duplicate
+// names, invalid assignments, names that shadow others, and super sends in
+// instance methods of a class without a superclass. Super sends on the class
+// side are left alone, since the metaclass of a root class still inherits
+// from Class and ^super new is idiomatic SOM. classes may be nil, in which
+// case nothing is known about superclasses.
+func Analyze(class *ast.Class, classes Classes) []diagnostic.Diagnostic {
+	a := &analyzer{
+		class:       class,
+		superclass:  "Object",
+		chainKnown:  true,
+		fields:      make(map[string]int),
+		classFields: make(map[string]int),
+	}
+
+	if class.Superclass != nil {
+		a.superclass = class.Superclass.Name
+	}
+	if class.Name != nil && class.Name.Name == "Object" && class.Superclass == nil {
+		a.superclass = "nil"
+	}
+
+	instanceFields, classFields := a.inheritedFields(classes)
+	a.declareFields(a.fields, instanceFields, class.InstanceFields)
+	a.declareFields(a.classFields, classFields, class.ClassFields)
+
+	for _, m := range class.InstanceMethods {
+		a.analyzeMethod(m)
+	}
+	for _, m := range class.ClassMethods {
+		a.analyzeMethod(m)
+	}
+
+	return a.diags
+}
+
+// inheritedFields returns the instance and class-side fields declared by the
+// superclasses of the class, furthest superclass first.
+func (a *analyzer) inheritedFields(classes Classes) ([]*ast.Ident, []*ast.Ident) {
+	var chain []*ast.Class
+
+	seen := make(map[string]bool)
+	if a.class.Name != nil {
+		seen[a.class.Name.Name] = true
+	}
+	for name := a.superclass; name != "nil"; {
+		if seen[name] {
+			var node ast.Node = a.class
+			if a.class.Superclass != nil {
+				node = a.class.Superclass
+			}
+			a.errorf(node, "class hierarchy of %s is circular", name)
+			break
+		}
+		seen[name] = true
+
+		var super *ast.Class
+		if classes != nil {
+			super = classes.LookupClass(name)
+		}
+		if super == nil {
+			a.chainKnown = false
+			break
+		}
+		chain = append([]*ast.Class{super}, chain...)
+
+		name = "Object"
+		switch {
+		case super.Superclass != nil:
+			name = super.Superclass.Name
+		case super.Name != nil && super.Name.Name == "Object":
+			name = "nil"
+		}
+	}
+
+	var instanceFields, classFields []*ast.Ident
+	for _, c := range chain {
+		instanceFields = append(instanceFields, c.InstanceFields...)
+		classFields = append(classFields, c.ClassFields...)
+	}
+
+	return instanceFields, classFields
+}
+
+func (a *analyzer) declareFields(fields map[string]int, inherited, declared []*ast.Ident) {
+	for _, f := range inherited {
+		fields[f.Name] = len(fields)
+	}
+
+	for _, f := range declared {
+		if !a.checkName(f) {
+			continue
+		}
+
+		if _, ok := fields[f.Name]; ok {
+			a.errorf(f, "duplicate field %s", f.Name)
+			continue
+		}
+		fields[f.Name] = len(fields)
+	}
+}
+
+func (a *analyzer) analyzeMethod(m *ast.Method) {
+	a.classSide = m.ClassSide
+
+	s := newScope(nil)
+	for _, arg := range m.Arguments {
+		a.declare(s, arg, ast.ArgumentVariable)
+	}
+	for _, local := range m.Locals {
+		a.declare(s, local, ast.LocalVariable)
+	}
+
+	a.analyzeBody(s, m.Body)
+}
+
+func (a *analyzer) analyzeBody(s *scope, body []ast.Expression) {
+	for _, expr := range body {
+		a.analyzeExpression(s, expr)
+	}
+}
+
+func (a *analyzer) analyzeExpression(s *scope, expr ast.Expression) {
+	switch e := expr.(type) {
+	case *ast.Variable:
+		a.resolve(s, e)
+	case *ast.Assignment:
+		a.resolve(s, e.Variable)
+		switch e.Variable.Kind {
+		case ast.LocalVariable, ast.FieldVariable, ast.ClassFieldVariable, ast.UnresolvedVariable:
+		case ast.ArgumentVariable:
+			a.errorf(e.Variable, "cannot assign to argument %s", e.Variable.Name)
+		default:
+			a.errorf(e.Variable, "cannot assign to %s", e.Variable.Name)
+		}
+		a.analyzeExpression(s, e.Value)
+	case *ast.Return:
+		a.analyzeExpression(s, e.Value)
+	case *ast.Send:
+		a.analyzeExpression(s, e.Receiver)
+		if v, ok := e.Receiver.(*ast.Variable); ok && v.Kind == ast.SuperVariable && !a.classSide && a.superclass == "nil" {
+			a.warnf(v, "super used in a class without a superclass")
+			a.diags[len(a.diags)-1].Hint = fmt.Sprintf("the lookup of %s starts at nil and always fails", e.Selector)
+		}
+		for _, arg := range e.Arguments {
+			a.analyzeExpression(s, arg)
+		}
+	case *ast.Block:
+		blockScope := newScope(s)
+		for _, param := range e.Parameters {
+			a.declare(blockScope, param, ast.ArgumentVariable)
+		}
+		for _, local := range e.Locals {
+			a.declare(blockScope, local, ast.LocalVariable)
+		}
+		a.analyzeBody(blockScope, e.Body)
+	case *ast.ArrayLiteral:
+		for _, element := range e.Elements {
+			a.analyzeExpression(s, element)
+		}
+	}
+}
+
+// declare adds an argument or local to s, reporting duplicates in s and
+// warning about names that shadow an outer declaration or a field.
+func (a *analyzer) declare(s *scope, ident *ast.Ident, kind ast.VariableKind) {
+	if !a.checkName(ident) {
+		return
+	}
+
+	if previous, ok := s.names[ident.Name]; ok {
+		a.errorf(ident, "duplicate %s %s", kindName(kind), ident.Name)
+		a.diags[len(a.diags)-1].Hint = fmt.Sprintf("%s is already declared as %s at %d:%d",
+			ident.Name, article(kindName(previous.kind)), previous.ident.Pos().Line, previous.ident.Pos().Column)
+		return
+	}
+
+	for outer := s.parent; outer != nil; outer = outer.parent {
+		if previous, ok := outer.names[ident.Name]; ok {
+			a.warnf(ident, "%s %s shadows %s declared at %d:%d", kindName(kind), ident.Name,
+				article("outer "+kindName(previous.kind)), previous.ident.Pos().Line, previous.ident.Pos().Column)
+			break
+		}
+	}
+
+	if _, ok := a.currentFields()[ident.Name]; ok {
+		a.warnf(ident, "%s %s shadows a field", kindName(kind), ident.Name)
+	}
+
+	s.names[ident.Name] = declaration{kind: kind, index: s.count[kind], ident: ident}
+	s.count[kind]++
+}
+
+// checkName reports declarations of pseudo-variables such as self or nil.
+func (a *analyzer) checkName(ident *ast.Ident) bool {
+	if _, ok := pseudoVariables[ident.Name]; ok {
+		a.errorf(ident, "cannot use %s as a name", ident.Name)
+		return false
+	}
+
+	return true
+}
+
+func (a *analyzer) resolve(s *scope, v *ast.Variable) {
+	if kind, ok := pseudoVariables[v.Name]; ok {
+		v.Kind = kind
+		return
+	}
+
+	for level, current := 0, s; current != nil; level, current = level+1, current.parent {
+		if d, ok := current.names[v.Name]; ok {
+			v.Kind, v.Index, v.Level = d.kind, d.index, level
+			return
+		}
+	}
+
+	if index, ok := a.currentFields()[v.Name]; ok {
+		v.Kind, v.Index = ast.FieldVariable, index
+		if a.classSide {
+			v.Kind = ast.ClassFieldVariable
+		}
+		return
+	}
+
+	if unicode.IsUpper(rune(v.Name[0])) || lowercaseGlobals[v.Name] {
+		v.Kind = ast.GlobalVariable
+		return
+	}
+
+	if a.chainKnown {
+		a.errorf(v, "undefined variable %s", v.Name)
+		return
+	}
+
+	a.warnf(v, "undefined variable %s", v.Name)
+	a.diags[len(a.diags)-1].Hint = fmt.Sprintf("%s may be a field inherited from %s, which wasn't found", v.Name, a.superclass)
+}
+
+func (a *analyzer) currentFields() map[string]int {
+	if a.classSide {
+		return a.classFields
+	}
+
+	return a.fields
+}
+
+func (a *analyzer) errorf(node ast.Node, format string, args ...interface{}) {
+	a.report(diagnostic.Error, node, format, args...)
+}
+
+func (a *analyzer) warnf(node ast.Node, format string, args ...interface{}) {
+	a.report(diagnostic.Warning, node, format, args...)
+}
+
+func (a *analyzer) report(severity diagnostic.Severity, node ast.Node, format string, args ...interface{}) {
+	a.diags = append(a.diags, diagnostic.Diagnostic{
+		Severity: severity,
+		Pos:      node.Pos(),
+		End:      node.End(),
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func kindName(kind ast.VariableKind) string {
+	if kind == ast.ArgumentVariable {
+		return "argument"
+	}
+
+	return "local"
+}
+
+func article(noun string) string {
+	switch noun[0] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return "an " + noun
+	}
+
+	return "a " + noun
+}
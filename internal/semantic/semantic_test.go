@@ -0,0 +1,162 @@
+package semantic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtarcea/som/internal/ast"
+	"github.com/gtarcea/som/internal/lexer"
+	"github.com/gtarcea/som/internal/parser"
+)
+
+type classMap map[string]*ast.Class
+
+func (m classMap) LookupClass(name string) *ast.Class {
+	return m[name]
+}
+
+func parse(t *testing.T, input string) *ast.Class {
+	class, err := parser.New(lexer.NewLexer(input)).Parse()
+	require.NoError(t, err)
+	return class
+}
+
+func TestAnalyzeBindings(t *testing.T) {
+	classes := classMap{
+		"Object": parse(t, "Object = nil ( | hash | ---- | count | )"),
+	}
+	class := parse(t, `Point = (
+  | x y |
+  x: ax y: ay = ( | sum | sum := ax + ay. ^[:z | sum + z + x] value: Nil )
+  ----
+  | origin |
+  origin = ( ^origin ifNil: [ origin := self new ] )
+)`)
+
+	diags := Analyze(class, classes)
+	require.Empty(t, diags)
+
+	body := class.InstanceMethods[0].Body
+	assign := body[0].(*ast.Assignment)
+	requireVariable(t, assign.Variable, ast.LocalVariable, 0, 0)
+	sum := assign.Value.(*ast.Send)
+	requireVariable(t, sum.Receiver.(*ast.Variable), ast.ArgumentVariable, 0, 0)
+	requireVariable(t, sum.Arguments[0].(*ast.Variable), ast.ArgumentVariable, 1, 0)
+
+	send := body[1].(*ast.Return).Value.(*ast.Send)
+	requireVariable(t, send.Arguments[0].(*ast.Variable), ast.GlobalVariable, 0, 0)
+	block := send.Receiver.(*ast.Block)
+	plusX := block.Body[0].(*ast.Send)
+	plusZ := plusX.Receiver.(*ast.Send)
+	requireVariable(t, plusZ.Receiver.(*ast.Variable), ast.LocalVariable, 0, 1)
+	requireVariable(t, plusZ.Arguments[0].(*ast.Variable), ast.ArgumentVariable, 0, 0)
+	// Inherited hash comes first
+	requireVariable(t, plusX.Arguments[0].(*ast.Variable), ast.FieldVariable, 1, 0)
+
+	ifNil := class.ClassMethods[0].Body[0].(*ast.Return).Value.(*ast.Send)
+	requireVariable(t, ifNil.Receiver.(*ast.Variable), ast.ClassFieldVariable, 1, 0)
+	newSend := ifNil.Arguments[0].(*ast.Block).Body[0].(*ast.Assignment).Value.(*ast.Send)
+	requireVariable(t, newSend.Receiver.(*ast.Variable), ast.SelfVariable, 0, 0)
+}
+
+func TestAnalyzeDiagnostics(t *testing.T) {
+	classes := classMap{
+		"Object": parse(t, "Object = nil ( | hash | )"),
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		classes  Classes
+		expected []string
+	}{
+		{
+			name:     "undefined variable",
+			input:    "Foo = ( a = ( ^b ) )",
+			classes:  classes,
+			expected: []string{"error 1:16: undefined variable b"},
+		},
+		{
+			name:     "undefined variable with unknown superclass",
+			input:    "Foo = Bar ( a = ( ^b ) )",
+			classes:  classes,
+			expected: []string{"warning 1:20: undefined variable b"},
+		},
+		{
+			name:    "duplicate names",
+			input:   "Foo = ( | a hash a | b: x c: x = ( | x | ) )",
+			classes: classes,
+			expected: []string{
+				"error 1:13: duplicate field hash",
+				"error 1:18: duplicate field a",
+				"error 1:30: duplicate argument x",
+				"error 1:38: duplicate local x",
+			},
+		},
+		{
+			name:    "invalid assignments",
+			input:   "Foo = ( a: x = ( x := 1. self := 2. Nil := 3. nil := 4 ) )",
+			classes: classes,
+			expected: []string{
+				"error 1:18: cannot assign to argument x",
+				"error 1:26: cannot assign to self",
+				"error 1:37: cannot assign to Nil",
+				"error 1:47: cannot assign to nil",
+			},
+		},
+		{
+			name:    "shadowing",
+			input:   "Foo = ( a: hash = ( ^[:hash | [ | hash | hash ] ] ) )",
+			classes: classes,
+			expected: []string{
+				"warning 1:12: argument hash shadows a field",
+				"warning 1:24: argument hash shadows an outer argument declared at 1:12",
+				"warning 1:24: argument hash shadows a field",
+				"warning 1:35: local hash shadows an outer argument declared at 1:24",
+				"warning 1:35: local hash shadows a field",
+			},
+		},
+		{
+			name:     "super on the class side",
+			input:    "Foo = ( ---- new: x = ( ^super new initialize: x ) )",
+			classes:  classes,
+			expected: nil,
+		},
+		{
+			name:     "super without a superclass",
+			input:    "Foo = nil ( a = ( ^super hash ) ---- new = ( ^super new ) )",
+			classes:  classes,
+			expected: []string{"warning 1:20: super used in a class without a superclass"},
+		},
+		{
+			name:     "pseudo-variable as a name",
+			input:    "Foo = ( a: self = ( | thisContext | ^self ) )",
+			classes:  classes,
+			expected: []string{"error 1:12: cannot use self as a name", "error 1:23: cannot use thisContext as a name"},
+		},
+		{
+			name:     "circular hierarchy",
+			input:    "Foo = Foo ( )",
+			classes:  classes,
+			expected: []string{"error 1:7: class hierarchy of Foo is circular"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var messages []string
+			for _, d := range Analyze(parse(t, test.input), test.classes) {
+				messages = append(messages, d.Severity.String()+" "+d.Error())
+			}
+			require.Equal(t, test.expected, messages)
+		})
+	}
+}
+
+func requireVariable(t *testing.T, v *ast.Variable, kind ast.VariableKind, index, level int) {
+	t.Helper()
+	require.Equal(t, kind, v.Kind, "kind of %s", v.Name)
+	require.Equal(t, index, v.Index, "index of %s", v.Name)
+	require.Equal(t, level, v.Level, "level of %s", v.Name)
+}
@@ -2,13 +2,29 @@ package token
 
 type Type string
 
+// Position is a location in the source. Offset is a byte offset starting at 0,
+// Line and Column start at 1 and Column counts bytes.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
 type Token struct {
 	Type    Type
 	Literal string
+
+	// Pos is the position of the first character of the token and End the
+	// position just after its last character.
+	Pos Position
+	End Position
 }
 
 const (
-	COMMENT    = ""
+	ILLEGAL = "illegal"
+	EOF     = "eof"
+
+	COMMENT    = "comment"
 	WHITESPACE = ""
 
 	PRIMITIVE = "primitive"
@@ -38,6 +54,9 @@ const (
 	PERCENT = "%"
 	COMMA   = ","
 
+	// Two or more operator characters in a row, eg <= or ==
+	OPERATOR_SEQUENCE = "operator_sequence"
+
 	SINGLE_QUOTE = "'"
 
 	COLON = ":"
@@ -0,0 +1,388 @@
+package vet
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gtarcea/som/internal/ast"
+	"github.com/gtarcea/som/internal/diagnostic"
+	"github.com/gtarcea/som/internal/token"
+)
+
+// UnusedAnalyzer reports locals that are never read and method arguments that
+// are never used. Arguments of methods that override an inherited one, or
+// that are empty or only call subclassResponsibility, are left alone since
+// they must take the arguments whether or not they use them. So are block
+// parameters, which are often fixed by the message the block is passed to.
+var UnusedAnalyzer = &Analyzer{
+	Name: "unused",
+	Doc:  "report unused locals and arguments",
+	Run:  runUnused,
+}
+
+// frame holds the arguments and locals of a method or block, and whether each
+// has been used.
+type frame struct {
+	names map[string]bool
+}
+
+func newFrame(idents ...[]*ast.Ident) *frame {
+	f := &frame{names: make(map[string]bool)}
+	for _, list := range idents {
+		for _, ident := range list {
+			f.names[ident.Name] = false
+		}
+	}
+
+	return f
+}
+
+func runUnused(pass *Pass) {
+	for _, m := range append(pass.Class.InstanceMethods, pass.Class.ClassMethods...) {
+		if m.Primitive {
+			continue
+		}
+
+		f := newFrame(m.Arguments, m.Locals)
+		markUsed(pass, []*frame{f}, m.Body)
+
+		if !isStub(m) && !overrides(pass, m) {
+			reportUnused(pass, f, m.Arguments, "argument")
+		}
+		reportUnused(pass, f, m.Locals, "local")
+	}
+}
+
+// markUsed walks body marking the arguments and locals it reads in frames, the
+// innermost scope last, and reports unused locals of the blocks inside it.
+// Assigning to a local doesn't count as using it.
+func markUsed(pass *Pass, frames []*frame, body []ast.Expression) {
+	for _, expr := range body {
+		ast.Inspect(expr, func(node ast.Node) bool {
+			switch n := node.(type) {
+			case *ast.Variable:
+				if (n.Kind == ast.ArgumentVariable || n.Kind == ast.LocalVariable) && n.Level < len(frames) {
+					names := frames[len(frames)-1-n.Level].names
+					if _, ok := names[n.Name]; ok {
+						names[n.Name] = true
+					}
+				}
+			case *ast.Assignment:
+				markUsed(pass, frames, []ast.Expression{n.Value})
+				return false
+			case *ast.Block:
+				f := newFrame(n.Parameters, n.Locals)
+				markUsed(pass, append(frames[:len(frames):len(frames)], f), n.Body)
+				reportUnused(pass, f, n.Locals, "local")
+				return false
+			}
+			return true
+		})
+	}
+}
+
+func reportUnused(pass *Pass, f *frame, idents []*ast.Ident, kind string) {
+	for _, ident := range idents {
+		if used, ok := f.names[ident.Name]; ok && !used {
+			pass.Reportf(ident, "%s %s is never used", kind, ident.Name)
+			// Only report the first of duplicate names
+			f.names[ident.Name] = true
+		}
+	}
+}
+
+// isStub reports whether m is empty or only calls subclassResponsibility.
+func isStub(m *ast.Method) bool {
+	switch len(m.Body) {
+	case 0:
+		return true
+	case 1:
+		expr := m.Body[0]
+		if r, ok := expr.(*ast.Return); ok {
+			expr = r.Value
+		}
+		send, ok := expr.(*ast.Send)
+		return ok && send.Selector == "subclassResponsibility"
+	}
+
+	return false
+}
+
+// overrides reports whether a superclass of the class being checked has a
+// method with the same selector as m on the same side.
+func overrides(pass *Pass, m *ast.Method) bool {
+	found := false
+	superclasses(pass, func(c *ast.Class) bool {
+		for _, sm := range sideMethods(c, m.ClassSide) {
+			if sm.Selector == m.Selector {
+				found = true
+			}
+		}
+		return !found
+	})
+
+	return found
+}
+
+// superclasses calls f for each loaded superclass of the class being checked,
+// nearest first, until f returns false.
+func superclasses(pass *Pass, f func(*ast.Class) bool) {
+	seen := make(map[*ast.Class]bool)
+	for c := superclass(pass.Program, pass.Class); c != nil && !seen[c]; c = superclass(pass.Program, c) {
+		seen[c] = true
+		if !f(c) {
+			return
+		}
+	}
+}
+
+func superclass(program *Program, c *ast.Class) *ast.Class {
+	switch {
+	case c.Superclass != nil:
+		return program.LookupClass(c.Superclass.Name)
+	case c.Name != nil && c.Name.Name == "Object":
+		return nil
+	}
+
+	return program.LookupClass("Object")
+}
+
+func sideMethods(c *ast.Class, classSide bool) []*ast.Method {
+	if classSide {
+		return c.ClassMethods
+	}
+
+	return c.InstanceMethods
+}
+
+// BlockValueAnalyzer reports block literals that are evaluated as soon as they
+// are created, [ ... ] value, which is the same as the statements themselves.
+var BlockValueAnalyzer = &Analyzer{
+	Name: "blockvalue",
+	Doc:  "report block literals that are sent value immediately",
+	Run: func(pass *Pass) {
+		ast.Inspect(pass.Class, func(node ast.Node) bool {
+			send, ok := node.(*ast.Send)
+			if !ok || !(send.Selector == "value" || strings.HasPrefix(send.Selector, "value:")) {
+				return true
+			}
+			if _, ok := send.Receiver.(*ast.Block); ok {
+				pass.Report(diagnostic.Diagnostic{
+					Severity: diagnostic.Warning,
+					Pos:      send.Pos(),
+					End:      send.End(),
+					Message:  fmt.Sprintf("block literal is sent %s immediately", send.Selector),
+					Hint:     "use the statements of the block directly",
+				})
+			}
+			return true
+		})
+	},
+}
+
+// NilCompareAnalyzer reports = and ~= comparisons with nil, which depend on
+// the other side implementing = sensibly, rather than isNil and notNil.
+var NilCompareAnalyzer = &Analyzer{
+	Name: "nilcompare",
+	Doc:  "report comparisons with nil using = or ~=",
+	Run: func(pass *Pass) {
+		ast.Inspect(pass.Class, func(node ast.Node) bool {
+			send, ok := node.(*ast.Send)
+			if !ok || (send.Selector != "=" && send.Selector != "~=") {
+				return true
+			}
+			if !isNil(send.Receiver) && !isNil(send.Arguments[0]) {
+				return true
+			}
+
+			replacement := "isNil"
+			if send.Selector == "~=" {
+				replacement = "notNil"
+			}
+			pass.Report(diagnostic.Diagnostic{
+				Severity: diagnostic.Warning,
+				Pos:      send.Pos(),
+				End:      send.End(),
+				Message:  fmt.Sprintf("comparison with nil using %s", send.Selector),
+				Hint:     "use " + replacement,
+			})
+			return true
+		})
+	},
+}
+
+func isNil(expr ast.Expression) bool {
+	v, ok := expr.(*ast.Variable)
+	return ok && v.Name == "nil"
+}
+
+// SelfAssignAnalyzer reports assignments of a variable to itself.
+var SelfAssignAnalyzer = &Analyzer{
+	Name: "selfassign",
+	Doc:  "report assignments of a variable to itself",
+	Run: func(pass *Pass) {
+		ast.Inspect(pass.Class, func(node ast.Node) bool {
+			if a, ok := node.(*ast.Assignment); ok {
+				if v, ok := a.Value.(*ast.Variable); ok && v.Name == a.Variable.Name {
+					pass.Reportf(a, "self-assignment of %s", v.Name)
+				}
+			}
+			return true
+		})
+	},
+}
+
+// OverrideAnalyzer reports methods that look like they override an inherited
+// method but take a different number of arguments, such as atput: where a
+// superclass has at:put:, so that they override nothing. A unary selector and
+// a one keyword selector with the same name, such as value and value:, are
+// left alone since they are usually a getter and setter pair.
+var OverrideAnalyzer = &Analyzer{
+	Name: "override",
+	Doc:  "report methods whose selector differs from an inherited one only in its arguments",
+	Run: func(pass *Pass) {
+		for _, m := range append(pass.Class.InstanceMethods, pass.Class.ClassMethods...) {
+			if isBinary(m.Selector) {
+				continue
+			}
+
+			name := strings.Replace(m.Selector, ":", "", -1)
+			superclasses(pass, func(c *ast.Class) bool {
+				methods := sideMethods(c, m.ClassSide)
+				for _, sm := range methods {
+					if sm.Selector == m.Selector {
+						return false
+					}
+				}
+
+				for _, sm := range methods {
+					if strings.Replace(sm.Selector, ":", "", -1) != name || accessorPair(m.Selector, sm.Selector) {
+						continue
+					}
+					pass.Report(diagnostic.Diagnostic{
+						Severity: diagnostic.Warning,
+						Pos:      m.Pos(),
+						End:      patternEnd(m),
+						Message: fmt.Sprintf("%s takes %s but %s>>%s takes %s", m.Selector,
+							arguments(arity(m.Selector)), c.Name.Name, sm.Selector, arguments(arity(sm.Selector))),
+						Hint: fmt.Sprintf("%s doesn't override %s", m.Selector, sm.Selector),
+					})
+					return false
+				}
+				return true
+			})
+		}
+	},
+}
+
+// accessorPair reports whether one of a and b is unary and the other takes a
+// single argument, such as value and value:.
+func accessorPair(a, b string) bool {
+	if arity(a) > arity(b) {
+		a, b = b, a
+	}
+
+	return arity(a) == 0 && arity(b) == 1
+}
+
+// patternEnd returns the end of the pattern of m, the selector and arguments
+// before the =.
+func patternEnd(m *ast.Method) token.Position {
+	if n := len(m.Arguments); n > 0 {
+		return m.Arguments[n-1].End()
+	}
+
+	return m.Parts[len(m.Parts)-1].End
+}
+
+func arguments(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+
+	return fmt.Sprintf("%d arguments", n)
+}
+
+// SelectorAnalyzer reports sends of selectors that no loaded class implements.
+// It only runs when Object is loaded, without the core library nearly every
+// send would be reported.
+var SelectorAnalyzer = &Analyzer{
+	Name: "selector",
+	Doc:  "report sends of selectors no loaded class implements",
+	Run: func(pass *Pass) {
+		if pass.Program.LookupClass("Object") == nil {
+			return
+		}
+
+		ast.Inspect(pass.Class, func(node ast.Node) bool {
+			send, ok := node.(*ast.Send)
+			if !ok || pass.Program.Implements(send.Selector) {
+				return true
+			}
+
+			d := diagnostic.Diagnostic{
+				Severity: diagnostic.Warning,
+				Pos:      send.Parts[0].Pos,
+				End:      send.Parts[len(send.Parts)-1].End,
+				Message:  fmt.Sprintf("no class implements %s", send.Selector),
+			}
+			if s := suggest(send.Selector, pass.Program.selectors); s != "" {
+				d.Hint = fmt.Sprintf("did you mean %s?", s)
+			}
+			pass.Report(d)
+			return true
+		})
+	},
+}
+
+// suggest returns the selector in selectors taking the same number of
+// arguments that is closest to selector, or "" if none is close.
+func suggest(selector string, selectors map[string]bool) string {
+	var candidates []string
+	for s := range selectors {
+		if arity(s) == arity(selector) {
+			candidates = append(candidates, s)
+		}
+	}
+	sort.Strings(candidates)
+
+	best, bestDistance := "", len(selector)/3+1
+	for _, s := range candidates {
+		if d := distance(strings.ToLower(selector), strings.ToLower(s)); d < bestDistance {
+			best, bestDistance = s, d
+		}
+	}
+
+	return best
+}
+
+// distance is the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		diagonal := row[0]
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			diagonal, row[j] = row[j], min(min(row[j]+1, row[j-1]+1), diagonal+cost)
+		}
+	}
+
+	return row[len(b)]
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
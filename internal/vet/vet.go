@@ -0,0 +1,130 @@
+// Package vet finds suspicious constructs in SOM classes, code that is legal
+// but probably not what was meant.
+package vet
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gtarcea/som/internal/ast"
+	"github.com/gtarcea/som/internal/diagnostic"
+	"github.com/gtarcea/som/internal/semantic"
+)
+
+// Analyzer is a single check. Run reports what it finds through the Pass.
+type Analyzer struct {
+	Name string
+	Doc  string
+	Run  func(*Pass)
+}
+
+// Pass is what an analyzer is given to check a class. The class has been
+// through the semantic pass, so its variables are resolved.
+type Pass struct {
+	Class   *ast.Class
+	Program *Program
+	diags   []diagnostic.Diagnostic
+}
+
+// Report adds a diagnostic for the class.
+func (p *Pass) Report(d diagnostic.Diagnostic) {
+	p.diags = append(p.diags, d)
+}
+
+// Reportf adds a warning about node.
+func (p *Pass) Reportf(node ast.Node, format string, args ...interface{}) {
+	p.Report(diagnostic.Diagnostic{
+		Severity: diagnostic.Warning,
+		Pos:      node.Pos(),
+		End:      node.End(),
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// Analyzers are all the checks, in the order they run.
+var Analyzers = []*Analyzer{
+	UnusedAnalyzer,
+	BlockValueAnalyzer,
+	NilCompareAnalyzer,
+	SelfAssignAnalyzer,
+	OverrideAnalyzer,
+	SelectorAnalyzer,
+}
+
+// Program is the set of classes that are loaded, the class being checked
+// among them.
+type Program struct {
+	classes   map[string]*ast.Class
+	selectors map[string]bool
+}
+
+// NewProgram makes a Program from classes. When two classes have the same
+// name the later one wins, as it would on a class path.
+func NewProgram(classes []*ast.Class) *Program {
+	p := &Program{
+		classes:   make(map[string]*ast.Class),
+		selectors: make(map[string]bool),
+	}
+
+	for _, c := range classes {
+		if c.Name != nil {
+			p.classes[c.Name.Name] = c
+		}
+		for _, m := range append(c.InstanceMethods, c.ClassMethods...) {
+			p.selectors[m.Selector] = true
+		}
+	}
+
+	return p
+}
+
+// LookupClass returns the loaded class called name, or nil.
+func (p *Program) LookupClass(name string) *ast.Class {
+	return p.classes[name]
+}
+
+// Implements reports whether any loaded class has a method for selector, on
+// either side.
+func (p *Program) Implements(selector string) bool {
+	return p.selectors[selector]
+}
+
+// Check resolves class with the semantic pass and runs analyzers over it. The
+// diagnostics from both are returned together in source order.
+func Check(class *ast.Class, program *Program, analyzers []*Analyzer) []diagnostic.Diagnostic {
+	var classes semantic.Classes
+	if program != nil {
+		classes = program
+	} else {
+		program = NewProgram([]*ast.Class{class})
+	}
+
+	pass := &Pass{Class: class, Program: program}
+	pass.diags = semantic.Analyze(class, classes)
+	for _, a := range analyzers {
+		a.Run(pass)
+	}
+
+	sort.SliceStable(pass.diags, func(i, j int) bool {
+		return pass.diags[i].Pos.Offset < pass.diags[j].Pos.Offset
+	})
+
+	return pass.diags
+}
+
+// arity returns the number of arguments taken by selector.
+func arity(selector string) int {
+	switch {
+	case strings.HasSuffix(selector, ":"):
+		return strings.Count(selector, ":")
+	case isBinary(selector):
+		return 1
+	}
+
+	return 0
+}
+
+func isBinary(selector string) bool {
+	return selector != "" && strings.IndexByte("~&|*/\\+=><,@%-", selector[0]) != -1
+}
@@ -0,0 +1,140 @@
+package vet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtarcea/som/internal/ast"
+	"github.com/gtarcea/som/internal/lexer"
+	"github.com/gtarcea/som/internal/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Class {
+	class, err := parser.New(lexer.NewLexer(input)).Parse()
+	require.NoError(t, err)
+	return class
+}
+
+func TestCheck(t *testing.T) {
+	object := "Object = nil ( = other = primitive value = ( ^self ) printOn: stream = ( ) isNil = ( ^false ) " +
+		"ifTrue: block = ( ^nil ) + other = primitive printString = ( ^'' ) at: i put: v = primitive )"
+
+	tests := []struct {
+		name      string
+		input     string
+		analyzers []*Analyzer
+		expected  []string
+	}{
+		{
+			name:      "unused",
+			input:     "Foo = ( a: x b: y = ( | z w | w := 1. ^[:p | | q | x] ) printOn: stream = ( ^self ) c: x = ( ^self subclassResponsibility ) )",
+			analyzers: []*Analyzer{UnusedAnalyzer},
+			expected: []string{
+				"warning 1:17: argument y is never used",
+				"warning 1:25: local z is never used",
+				"warning 1:27: local w is never used",
+				"warning 1:48: local q is never used",
+			},
+		},
+		{
+			name:      "block value",
+			input:     "Foo = ( a = ( ^[ 1 ] value + ([:x | x ] value: 2) ) )",
+			analyzers: []*Analyzer{BlockValueAnalyzer},
+			expected: []string{
+				"warning 1:16: block literal is sent value immediately",
+				"warning 1:31: block literal is sent value: immediately",
+			},
+		},
+		{
+			name:      "nil comparison",
+			input:     "Foo = ( a: x = ( ^(x = nil) ifTrue: [ nil ~= x ] ) b: x = ( ^x == nil ) )",
+			analyzers: []*Analyzer{NilCompareAnalyzer},
+			expected: []string{
+				"warning 1:20: comparison with nil using =",
+				"warning 1:39: comparison with nil using ~=",
+			},
+		},
+		{
+			name:      "self-assignment",
+			input:     "Foo = ( | x | a = ( | y | x := x. y := x := x ) )",
+			analyzers: []*Analyzer{SelfAssignAnalyzer},
+			expected: []string{
+				"warning 1:27: self-assignment of x",
+				"warning 1:40: self-assignment of x",
+			},
+		},
+		{
+			name:      "override",
+			input:     "Foo = ( value: x = ( ^x ) printOn = ( ^self ) isNil = ( ^true ) + other = ( ^self ) atput: x = ( ^x ) )",
+			analyzers: []*Analyzer{OverrideAnalyzer},
+			expected: []string{
+				"warning 1:85: atput: takes 1 argument but Object>>at:put: takes 2 arguments",
+			},
+		},
+		{
+			name:      "selector",
+			input:     "Foo = ( a = ( ^(self isnil ifTrue: 1 ifFalse: 2) printString fooBar ) )",
+			analyzers: []*Analyzer{SelectorAnalyzer},
+			expected: []string{
+				"warning 1:22: no class implements isnil",
+				"warning 1:28: no class implements ifTrue:ifFalse:",
+				"warning 1:62: no class implements fooBar",
+			},
+		},
+		{
+			name:      "semantic",
+			input:     "Foo = ( a = ( ^b ) )",
+			analyzers: Analyzers,
+			expected:  []string{"error 1:16: undefined variable b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			class := parse(t, test.input)
+			program := NewProgram([]*ast.Class{parse(t, object), class})
+
+			var messages []string
+			for _, d := range Check(class, program, test.analyzers) {
+				messages = append(messages, d.Severity.String()+" "+d.Error())
+			}
+			require.Equal(t, test.expected, messages)
+		})
+	}
+}
+
+func TestSelectorHint(t *testing.T) {
+	object := parse(t, "Object = nil ( isNil = ( ^false ) at: i put: v = ( ^v ) )")
+	class := parse(t, "Foo = ( a = ( ^self isnil at: 1 putt: 2 ) )")
+
+	diags := Check(class, NewProgram([]*ast.Class{object, class}), []*Analyzer{SelectorAnalyzer})
+	require.Len(t, diags, 2)
+	require.Equal(t, "did you mean isNil?", diags[0].Hint)
+	require.Equal(t, "did you mean at:put:?", diags[1].Hint)
+}
+
+func TestOverrideSpan(t *testing.T) {
+	object := parse(t, "Object = nil ( at: i put: v = primitive )")
+	class := parse(t, "Foo = ( atput: x = ( ^x ) )")
+
+	diags := Check(class, NewProgram([]*ast.Class{object, class}), []*Analyzer{OverrideAnalyzer})
+	require.Len(t, diags, 1)
+	require.Equal(t, []int{8, 16}, []int{diags[0].Pos.Offset, diags[0].End.Offset})
+}
+
+func TestOverrideExactSelector(t *testing.T) {
+	// value: overrides Block>>value: even though Block>>value comes first
+	object := parse(t, "Object = nil ( )")
+	block := parse(t, "Block = ( value = primitive value: a = primitive valueWith = primitive )")
+	class := parse(t, "MyBlock = Block ( value: a = ( ^a ) valueWith: a = ( ^a ) )")
+
+	diags := Check(class, NewProgram([]*ast.Class{object, block, class}), []*Analyzer{OverrideAnalyzer})
+	require.Empty(t, diags)
+}
+
+func TestCheckWithoutProgram(t *testing.T) {
+	// Without Object loaded unknown selectors aren't reported
+	class := parse(t, "Foo = ( a = ( ^self fooBar ) )")
+	require.Empty(t, Check(class, nil, Analyzers))
+}